@@ -27,47 +27,75 @@ func (b bitset) first() uintptr {
 
 // removeFirst removes the first set bit (that is, resets the least significant set bit to 0).
 func (b bitset) removeFirst() bitset {
-	return b & ^(bitset(slotEmpty) << (bits.TrailingZeros64(uint64(b)) & ^7))
+	return b & ^(bitset(0x80) << (bits.TrailingZeros64(uint64(b)) & ^7))
 }
 
+// matchH2 matches the full slots holding the given h2.
+// It may report false positives among full slots, but never matches an empty
+// or deleted one: with the MSB clear, they differ from any h2|0x80 in the MSB.
+//
 //go:inline
 func matchH2(group uint64, h2 uint8) bitset {
-	v := group ^ (bitsetLSB * uint64(h2))
+	v := group ^ (bitsetLSB * uint64(h2|slotFull))
 	return bitset(((v - bitsetLSB) &^ v) & bitsetMSB)
 }
 
-// matchEmpty: Check if MSB is 1 AND bit 1 is 0.
-// (0x80 is 10000000, bit 1 is 0. 0xFE is 11111110, bit 1 is 1)
+// matchEmpty: Check if both the MSB and bit 6 are 0.
+// (0x00 has neither, 0x7E is 01111110 and has bit 6, Full slots have the MSB)
 //
 //go:inline
 func matchEmpty(group uint64) bitset {
-	return bitset((group &^ (group << 6)) & bitsetMSB)
+	return bitset(^(group | (group << 1)) & bitsetMSB)
 }
 
-// matchEmptyOrDeleted: Just check if the MSB is 1.
-// (Both 0x80 and 0xFE have it, Full slots don't)
+// matchEmptyOrDeleted: Just check if the MSB is 0.
+// (0x00 and 0x7E have it clear, and so does 0x40 during a compaction, Full slots don't)
 //
 //go:inline
 func matchEmptyOrDeleted(group uint64) bitset {
+	return bitset(^group & bitsetMSB)
+}
+
+// matchFull: Just check if the MSB is 1.
+// (Full slots hold a 7-bit h2 with the MSB set, Empty and Deleted both have it clear)
+//
+//go:inline
+func matchFull(group uint64) bitset {
 	return bitset(group & bitsetMSB)
 }
 
-// invertCtrls transforms control bytes for compaction:
-// Full (0x00-0x7F) -> Deleted (0xFE)
-// Deleted (0xFE) -> Empty (0x80)
-// Empty (0x80) -> Empty (0x80)
+// matchPending matches the slots marked as pending by a compaction, see markPending.
+// Unlike matchH2 it's exact for control bytes: a false positive needs a 0x41 byte
+// right after a matching one, and 0x41 is none of empty, deleted, pending or full.
 //
 //go:inline
-func invertCtrls(ctrl uint64) uint64 {
-	// Detect full slots (MSB=0)
-	isFull := ^ctrl & bitsetMSB
+func matchPending(group uint64) bitset {
+	v := group ^ (bitsetLSB * slotPending)
+	return bitset(((v - bitsetLSB) &^ v) & bitsetMSB)
+}
 
-	// Spread 0x80 -> 0xFE for full slots (set bits 7-1, leave bit 0 clear)
-	fullResult := isFull | (isFull >> 1) | (isFull >> 2) | (isFull >> 3) |
-		(isFull >> 4) | (isFull >> 5) | (isFull >> 6)
+// markPending transforms control bytes at the start of a compaction:
+// Full (0x80-0xFF) -> Pending (0x40)
+// Deleted (0x7E) -> Deleted (0x7E)
+// Empty (0x00) -> Empty (0x00)
+//
+//go:inline
+func markPending(ctrl uint64) uint64 {
+	// Detect full slots (MSB=1)
+	isFull := ctrl & bitsetMSB
+
+	// Clear the full bytes, then set 0x40 in each of them
+	return ctrl&^((isFull>>7)*0xFF) | isFull>>1
+}
 
-	// Empty/Deleted both map to 0x80 (just keep MSB)
-	highBits := ctrl & bitsetMSB
+// dropTombstones transforms control bytes at the end of a compaction:
+// Deleted (0x7E) -> Empty (0x00)
+// Full and Empty are left as they are, and no Pending slot is left by then.
+//
+//go:inline
+func dropTombstones(ctrl uint64) uint64 {
+	// Deleted is the only control byte with the MSB clear and bit 6 set
+	isDeleted := ^ctrl & (ctrl << 1) & bitsetMSB
 
-	return fullResult | highBits
+	return ctrl &^ ((isDeleted >> 7) * 0xFF)
 }
@@ -1,12 +1,13 @@
 package stablemap
 
 import (
+	"math/rand/v2"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 )
 
-func TestInvertCtrls(t *testing.T) {
+func TestMarkPending(t *testing.T) {
 	tests := []struct {
 		name  string
 		input uint64
@@ -14,55 +15,211 @@ func TestInvertCtrls(t *testing.T) {
 	}{
 		{
 			name:  "All empty",
-			input: 0x8080808080808080,
-			want:  0x8080808080808080,
+			input: 0x0000000000000000,
+			want:  0x0000000000000000,
 		},
 		{
 			name:  "All deleted",
-			input: 0xFEFEFEFEFEFEFEFE,
-			want:  0x8080808080808080,
+			input: 0x7E7E7E7E7E7E7E7E,
+			want:  0x7E7E7E7E7E7E7E7E,
 		},
 		{
 			name:  "All full (H2=0)",
-			input: 0x0000000000000000,
-			want:  0xFEFEFEFEFEFEFEFE,
+			input: 0x8080808080808080,
+			want:  0x4040404040404040,
 		},
 		{
 			name:  "All full (H2=0x7F)",
-			input: 0x7F7F7F7F7F7F7F7F,
-			want:  0xFEFEFEFEFEFEFEFE,
+			input: 0xFFFFFFFFFFFFFFFF,
+			want:  0x4040404040404040,
 		},
 		{
-			name:  "Mixed H2 values",
-			input: 0x0102030405060708,
-			want:  0xFEFEFEFEFEFEFEFE,
+			name:  "Mixed: full, empty, deleted",
+			input: 0x80_00_7E_C2_00_7E_FF_81,
+			want:  0x40_00_7E_40_00_7E_40_40,
 		},
 		{
-			name:  "Mixed: full, empty, deleted",
-			input: 0x00_80_FE_42_80_FE_7F_01,
-			want:  0xFE_80_80_FE_80_80_FE_FE,
+			name:  "Single full slot (first byte)",
+			input: 0x0000000000000080,
+			want:  0x0000000000000040,
 		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := markPending(tt.input)
+			require.Equal(t, tt.want, got, "markPending(0x%016X) = 0x%016X, want 0x%016X", tt.input, got, tt.want)
+		})
+	}
+}
+
+func TestDropTombstones(t *testing.T) {
+	tests := []struct {
+		name  string
+		input uint64
+		want  uint64
+	}{
 		{
-			name:  "Alternating full and empty",
-			input: 0x80_00_80_00_80_00_80_00,
-			want:  0x80_FE_80_FE_80_FE_80_FE,
+			name:  "All deleted",
+			input: 0x7E7E7E7E7E7E7E7E,
+			want:  0x0000000000000000,
 		},
 		{
-			name:  "Single full slot (first byte)",
-			input: 0x8080808080808000,
-			want:  0x80808080808080FE,
+			name:  "All full",
+			input: 0xFF80FEC2A0B0FF81,
+			want:  0xFF80FEC2A0B0FF81,
+		},
+		{
+			name:  "Mixed: full, empty, deleted",
+			input: 0x80_00_7E_C2_00_7E_FF_81,
+			want:  0x80_00_00_C2_00_00_FF_81,
 		},
 		{
 			name:  "Single deleted slot (last byte)",
-			input: 0xFE80808080808080,
+			input: 0x7E00000000000000,
+			want:  0x0000000000000000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := dropTombstones(tt.input)
+			require.Equal(t, tt.want, got, "dropTombstones(0x%016X) = 0x%016X, want 0x%016X", tt.input, got, tt.want)
+		})
+	}
+}
+
+func TestMatchFull(t *testing.T) {
+	tests := []struct {
+		name  string
+		input uint64
+		want  bitset
+	}{
+		{
+			name:  "All empty",
+			input: 0x0000000000000000,
+			want:  0,
+		},
+		{
+			name:  "All deleted",
+			input: 0x7E7E7E7E7E7E7E7E,
+			want:  0,
+		},
+		{
+			name:  "All full",
+			input: 0x808182FF90A0B0C0,
 			want:  0x8080808080808080,
 		},
+		{
+			name:  "Mixed: full, empty, deleted",
+			input: 0x80_00_7E_C2_00_7E_FF_81,
+			want:  0x80_00_00_80_00_00_80_80,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := invertCtrls(tt.input)
-			require.Equal(t, tt.want, got, "invertCtrls(0x%016X) = 0x%016X, want 0x%016X", tt.input, got, tt.want)
+			require.Equal(t, tt.want, matchFull(tt.input))
 		})
 	}
 }
+
+// randomCtrls returns a group of random control bytes: full, empty and deleted.
+func randomCtrls(rng *rand.Rand) uint64 {
+	var ctrl uint64
+	for i := range groupSize {
+		var b uint64
+		switch rng.IntN(4) {
+		case 0:
+			b = slotEmpty
+		case 1:
+			b = slotDeleted
+		default:
+			b = rng.Uint64N(0x80) | slotFull
+		}
+		ctrl |= b << (i * 8)
+	}
+
+	return ctrl
+}
+
+// markPendingRef is the byte-by-byte version of markPending.
+func markPendingRef(ctrl uint64) uint64 {
+	var result uint64
+	for i := range groupSize {
+		b := uint8(ctrl >> (i * 8))
+		if b&slotFull != 0 {
+			b = slotPending
+		}
+		result |= uint64(b) << (i * 8)
+	}
+
+	return result
+}
+
+// dropTombstonesRef is the byte-by-byte version of dropTombstones.
+func dropTombstonesRef(ctrl uint64) uint64 {
+	var result uint64
+	for i := range groupSize {
+		b := uint8(ctrl >> (i * 8))
+		if b == slotDeleted {
+			b = slotEmpty
+		}
+		result |= uint64(b) << (i * 8)
+	}
+
+	return result
+}
+
+// matchPendingRef is the byte-by-byte version of matchPending.
+func matchPendingRef(ctrl uint64) bitset {
+	var result bitset
+	for i := range groupSize {
+		if uint8(ctrl>>(i*8)) == slotPending {
+			result |= 0x80 << (i * 8)
+		}
+	}
+
+	return result
+}
+
+func TestCompactionCtrls_Random(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+
+	for range 100000 {
+		ctrl := randomCtrls(rng)
+		require.Equal(t, markPendingRef(ctrl), markPending(ctrl), "markPending(0x%016X)", ctrl)
+		require.Equal(t, dropTombstonesRef(ctrl), dropTombstones(ctrl), "dropTombstones(0x%016X)", ctrl)
+
+		// Halfway through a compaction: pending, relocated (full), deleted and empty slots
+		pending := markPending(ctrl)
+		for i := range groupSize {
+			if rng.IntN(2) == 0 {
+				pending = pending&^(0xFF<<(i*8)) | (ctrl & (0xFF << (i * 8)))
+			}
+		}
+		require.Equal(t, matchPendingRef(pending), matchPending(pending), "matchPending(0x%016X)", pending)
+		require.Zero(t, matchPending(ctrl), "matchPending(0x%016X)", ctrl)
+	}
+}
+
+// matchH2 may report false positives, which the key comparison filters out,
+// but only among full slots: empty and deleted slots keep stale keys around,
+// a match there could return a deleted entry.
+func TestMatchH2_FullSlotsOnly(t *testing.T) {
+	rng := rand.New(rand.NewPCG(3, 4))
+
+	// A tombstone's low 7 bits are the same as h2=0x7E
+	require.Zero(t, matchH2(0x7E7E7E7E7E7E7E7E, 0x7E))
+	require.Zero(t, matchH2(0x0000000000000000, 0))
+
+	for range 1000 {
+		ctrl := randomCtrls(rng)
+		full := matchFull(ctrl)
+
+		for h2 := range uint8(0x80) {
+			m := matchH2(ctrl, h2)
+			require.Zero(t, m&^full, "matchH2(0x%016X, 0x%02X)", ctrl, h2)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package stablemap
+
+// BucketedSet is a set that spreads keys over several independently allocated
+// StableSets, for sets too large to live comfortably in a single allocation.
+// Each bucket is a separate, smaller allocation and compacts on its own,
+// without touching the others.
+//
+// Every bucket shares the same hash function: a key is hashed once, and that hash
+// both selects the bucket and drives the lookup inside it.
+// Each bucket holds an equal share of the requested capacity, so a skewed key
+// distribution may fill one bucket (and return ErrTableFull) before the others.
+//
+// BucketedSet is NOT safe for concurrent use.
+type BucketedSet[K comparable] struct {
+	buckets  []StableSet[K]
+	hashFunc HashFunc[K]
+}
+
+// Returns a new instance of the bucketed set.
+// The capacity is split evenly between the buckets, and every bucket
+// is created with the given options.
+func NewBucketedSet[K comparable](capacity, buckets int, opts ...Option[K, struct{}]) *BucketedSet[K] {
+	buckets = max(buckets, 1)
+
+	bs := BucketedSet[K]{buckets: make([]StableSet[K], buckets)}
+	bs.hashFunc = initPartitions(len(bs.buckets), capacity, opts, func(i int) *table[K, struct{}] {
+		return &bs.buckets[i].table
+	})
+
+	return &bs
+}
+
+// Checks whether a key is in the set.
+func (bs *BucketedSet[K]) Has(key K) bool {
+	hash := bs.hashFunc(key)
+	_, ok := bs.buckets[partition(hash, len(bs.buckets))].getHashed(key, hash)
+
+	return ok
+}
+
+// Puts a key into the set.
+// Returns an error if the key's bucket is full.
+func (bs *BucketedSet[K]) Put(key K) error {
+	hash := bs.hashFunc(key)
+	return bs.buckets[partition(hash, len(bs.buckets))].setHashed(key, hash, struct{}{})
+}
+
+// Deletes a key from the set.
+func (bs *BucketedSet[K]) Delete(key K) bool {
+	hash := bs.hashFunc(key)
+	return bs.buckets[partition(hash, len(bs.buckets))].deleteHashed(key, hash)
+}
+
+// Returns the number of keys in the set.
+func (bs *BucketedSet[K]) Len() int {
+	var n int
+	for i := range bs.buckets {
+		n += bs.buckets[i].Len()
+	}
+
+	return n
+}
@@ -0,0 +1,61 @@
+package stablemap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBucketedSet(t *testing.T) {
+	bs := NewBucketedSet[int](16384, 8)
+	require.Len(t, bs.buckets, 8)
+	assert.Equal(t, 2048, bs.buckets[0].Stats().Capacity)
+
+	for i := range 10000 {
+		require.NoError(t, bs.Put(i))
+	}
+	assert.Equal(t, 10000, bs.Len())
+
+	// Keys are actually spread over the buckets
+	for i := range bs.buckets {
+		assert.Positive(t, bs.buckets[i].Len())
+		require.NoError(t, bs.buckets[i].CheckInvariants())
+	}
+
+	for i := range 5000 {
+		require.True(t, bs.Delete(i*2))
+	}
+	assert.False(t, bs.Delete(0))
+	assert.Equal(t, 5000, bs.Len())
+
+	for i := range 10000 {
+		assert.Equal(t, i%2 == 1, bs.Has(i), "key %d", i)
+	}
+	assert.False(t, bs.Has(-1))
+}
+
+func TestBucketedSet_SharedHashFunc(t *testing.T) {
+	bs := NewBucketedSet[string](64, 4)
+
+	for i := range bs.buckets {
+		assert.Equal(t, bs.hashFunc("foo"), bs.buckets[i].hashFunc("foo"))
+	}
+
+	// At least one bucket
+	assert.Len(t, NewBucketedSet[string](64, 0).buckets, 1)
+}
+
+func TestBucketedSet_OptionsNotAliased(t *testing.T) {
+	// Spare capacity past the options, where appending would write
+	opts := make([]Option[int, struct{}], 1, 2)
+	opts[0] = WithLoadFactor[int, struct{}](0.5)
+
+	bs := NewBucketedSet(64, 4, opts...)
+	assert.Nil(t, opts[:2][1])
+
+	for i := range bs.buckets {
+		assert.Equal(t, 0.5, bs.buckets[i].loadFactor)
+		assert.Equal(t, bs.hashFunc(1), bs.buckets[i].hashFunc(1))
+	}
+}
@@ -0,0 +1,425 @@
+package stablemap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"reflect"
+	"unsafe"
+)
+
+// Versions of the binary format, see WriteTo.
+const (
+	binaryVersion1 uint8 = 1 + iota
+	binaryVersion2
+
+	binaryVersion = binaryVersion2
+)
+
+// decodePrealloc bounds the number of entries the decoded table is sized for up front.
+// The encoded count isn't trusted until that many entries are actually read,
+// past it the table grows as they arrive.
+const decodePrealloc = 1 << 16
+
+var (
+	binaryMagic = [4]byte{'S', 'M', 'A', 'P'}
+	castagnoli  = crc32.MakeTable(crc32.Castagnoli)
+)
+
+type binaryHeader struct {
+	Magic   [4]byte
+	Version uint8
+	Count   uint64
+}
+
+// GobEncode implements gob.GobEncoder.
+// The map is encoded as its live key/value pairs rather than its internal layout,
+// so the result can be decoded into a map of any capacity. Values are omitted for
+// zero-sized value types (e.g. struct{}), which gob can't encode on its own.
+func (sm *StableMap[K, V]) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(uint64(sm.size)); err != nil {
+		return nil, err
+	}
+
+	if err := sm.encodeEntries(enc); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// GobDecode implements gob.GobDecoder.
+// The map is rebuilt from scratch with a capacity fitting the encoded entries.
+// The configuration of the map, e.g. a custom hash function, is preserved.
+// On error the map is left untouched.
+func (sm *StableMap[K, V]) GobDecode(data []byte) error {
+	var n uint64
+
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(&n); err != nil {
+		return err
+	}
+
+	t, err := sm.decodeEntries(dec, n)
+	if err != nil {
+		return err
+	}
+
+	sm.table = t
+
+	return nil
+}
+
+// GobEncode implements gob.GobEncoder.
+// The set is encoded as its keys, like a StableMap with struct{} values,
+// so either one decodes what the other encodes.
+func (ss *StableSet[K]) GobEncode() ([]byte, error) {
+	sm := StableMap[K, struct{}]{table: ss.table}
+
+	return sm.GobEncode()
+}
+
+// GobDecode implements gob.GobDecoder, rebuilding the set like StableMap.GobDecode.
+// On error the set is left untouched.
+func (ss *StableSet[K]) GobDecode(data []byte) error {
+	sm := StableMap[K, struct{}]{table: ss.table}
+	if err := sm.GobDecode(data); err != nil {
+		return err
+	}
+
+	ss.table = sm.table
+
+	return nil
+}
+
+// WriteTo implements io.WriterTo.
+// The live entries are streamed to w group by group, so the serialized map is never
+// held in memory as a whole. The binary format, shared with MarshalBinary, is:
+//
+//	magic    [4]byte "SMAP"
+//	version  uint8
+//	count    uint64, little-endian: the number of entries
+//	entries  gob stream of count keys, each followed by its value,
+//	         unless the value type is zero-sized (e.g. struct{})
+//	checksum uint32, little-endian: CRC-32C (Castagnoli) of every byte
+//	         from the magic to the end of the entries (since version 2)
+//
+// Version 1 has no checksum. Both versions are accepted by ReadFrom
+// and UnmarshalBinary, and the latest one is written.
+//
+// The checksum is a trailer rather than a header field, since it's only known
+// once every entry has been written.
+func (sm *StableMap[K, V]) WriteTo(w io.Writer) (int64, error) {
+	var (
+		cw     = &countingWriter{w: w}
+		crc    = crc32.New(castagnoli)
+		hw     = io.MultiWriter(cw, crc)
+		header = binaryHeader{Magic: binaryMagic, Version: binaryVersion, Count: uint64(sm.size)}
+	)
+
+	if err := binary.Write(hw, binary.LittleEndian, &header); err != nil {
+		return cw.n, err
+	}
+
+	if err := sm.encodeEntries(gob.NewEncoder(hw)); err != nil {
+		return cw.n, err
+	}
+
+	err := binary.Write(cw, binary.LittleEndian, crc.Sum32())
+
+	return cw.n, err
+}
+
+// ReadFrom implements io.ReaderFrom, accepting the stream produced by WriteTo
+// in any version of the binary format.
+// The table is sized from the header and entries are inserted as they're read,
+// but the map is only replaced once the checksum trailer is verified:
+// a corrupted stream returns an error wrapping ErrChecksumMismatch.
+// A stream with an unknown magic or version, or whose count exceeds the maximum
+// capacity, returns an error wrapping ErrInvalidFormat: the header is validated
+// before anything is allocated for it, since the checksum is only verified at the end.
+// The configuration of the map, e.g. a custom hash function, is preserved.
+// On error the map is left untouched.
+func (sm *StableMap[K, V]) ReadFrom(r io.Reader) (int64, error) {
+	var (
+		header binaryHeader
+		want   uint32
+	)
+
+	cr := &countingReader{r: r, crc: crc32.New(castagnoli)}
+	if err := binary.Read(cr, binary.LittleEndian, &header); err != nil {
+		return cr.n, err
+	}
+
+	switch {
+	case header.Magic != binaryMagic:
+		return cr.n, fmt.Errorf("%w: unknown magic %q", ErrInvalidFormat, header.Magic[:])
+	case header.Version < binaryVersion1 || header.Version > binaryVersion:
+		return cr.n, fmt.Errorf("%w: unsupported version %d", ErrInvalidFormat, header.Version)
+	}
+
+	t, err := sm.decodeEntries(gob.NewDecoder(cr), header.Count)
+	if err != nil {
+		return cr.n, err
+	}
+
+	if header.Version == binaryVersion1 {
+		sm.table = t
+		return cr.n, nil
+	}
+
+	got := cr.crc.Sum32()
+	cr.crc = nil
+
+	if err := binary.Read(cr, binary.LittleEndian, &want); err != nil {
+		return cr.n, err
+	}
+
+	if got != want {
+		return cr.n, fmt.Errorf("%w: computed %08x, stored %08x", ErrChecksumMismatch, got, want)
+	}
+
+	sm.table = t
+
+	return cr.n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, in the format written by WriteTo.
+func (sm *StableMap[K, V]) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := sm.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, accepting the format
+// produced by MarshalBinary. Trailing data is an error.
+// On error the map is left untouched.
+func (sm *StableMap[K, V]) UnmarshalBinary(data []byte) error {
+	// Shares the configuration, ReadFrom only replaces the table on success
+	decoded := StableMap[K, V]{table: sm.table}
+
+	r := bytes.NewReader(data)
+	if _, err := decoded.ReadFrom(r); err != nil {
+		return err
+	}
+
+	if r.Len() > 0 {
+		return fmt.Errorf("%d bytes of trailing data", r.Len())
+	}
+
+	sm.table = decoded.table
+
+	return nil
+}
+
+func (sm *StableMap[K, V]) encodeEntries(enc *gob.Encoder) error {
+	var err error
+
+	hasValues := unsafe.Sizeof(sm.emptyV) != 0
+
+	sm.all(func(key K, value V) bool {
+		if err = enc.Encode(key); err != nil {
+			return false
+		}
+
+		if hasValues {
+			err = enc.Encode(value)
+		}
+
+		return err == nil
+	})
+
+	return err
+}
+
+// decodeEntries builds a new table holding `n` entries read from dec.
+// A count above the maximum capacity returns an error wrapping ErrInvalidFormat.
+func (sm *StableMap[K, V]) decodeEntries(dec *gob.Decoder, n uint64) (table[K, V], error) {
+	var t table[K, V]
+
+	if n > maxCapacity {
+		return t, fmt.Errorf("%w: %d entries exceed the maximum capacity of %d", ErrInvalidFormat, n, uint(maxCapacity))
+	}
+
+	hasValues := unsafe.Sizeof(sm.emptyV) != 0
+
+	t.init(capacityForSize(int(min(n, decodePrealloc))), sm.decodeOptions()...)
+
+	for range n {
+		var (
+			key   K
+			value V
+		)
+
+		if err := dec.Decode(&key); err != nil {
+			return t, err
+		}
+
+		if hasValues {
+			if err := dec.Decode(&value); err != nil {
+				return t, err
+			}
+		}
+
+		if t.size == t.capacityEffective {
+			if err := t.reserve(int(t.size)); err != nil {
+				return t, err
+			}
+		}
+
+		if err := t.set(key, value); err != nil {
+			return t, err
+		}
+	}
+
+	return t, nil
+}
+
+// decodeOptions returns the options of the table a decoded map is rebuilt into:
+// the map's own configuration, or the defaults for a zero StableMap.
+func (sm *StableMap[K, V]) decodeOptions() []Option[K, V] {
+	if sm.groups == nil {
+		return []Option[K, V]{WithHashFunc[K, V](sm.hashFunc)}
+	}
+
+	return sm.options()
+}
+
+// MarshalJSON implements json.Marshaler.
+// Maps with string keys are encoded as a JSON object with sorted keys,
+// any other key type is encoded as an array of [key, value] pairs.
+func (sm *StableMap[K, V]) MarshalJSON() ([]byte, error) {
+	if reflect.TypeFor[K]().Kind() == reflect.String {
+		m := make(map[K]V, sm.size)
+		sm.all(func(key K, value V) bool {
+			m[key] = value
+			return true
+		})
+
+		return json.Marshal(m)
+	}
+
+	pairs := make([][2]any, 0, sm.size)
+	sm.all(func(key K, value V) bool {
+		pairs = append(pairs, [2]any{key, value})
+		return true
+	})
+
+	return json.Marshal(pairs)
+}
+
+// UnmarshalJSON implements json.Unmarshaler, accepting the format produced by MarshalJSON.
+// The map is rebuilt from scratch with a capacity fitting the decoded entries.
+// The configuration of the map, e.g. a custom hash function, is preserved.
+// On error the map is left untouched.
+func (sm *StableMap[K, V]) UnmarshalJSON(data []byte) error {
+	var t table[K, V]
+
+	if reflect.TypeFor[K]().Kind() == reflect.String {
+		var m map[K]V
+		if err := json.Unmarshal(data, &m); err != nil {
+			return err
+		}
+
+		t.init(capacityForSize(len(m)), sm.decodeOptions()...)
+		// A custom load factor may need more room than the default sizing gives
+		if err := t.reserve(len(m)); err != nil {
+			return err
+		}
+
+		for key, value := range m {
+			if err := t.set(key, value); err != nil {
+				return err
+			}
+		}
+
+		sm.table = t
+
+		return nil
+	}
+
+	var pairs [][2]json.RawMessage
+	if err := json.Unmarshal(data, &pairs); err != nil {
+		return err
+	}
+
+	t.init(capacityForSize(len(pairs)), sm.decodeOptions()...)
+	if err := t.reserve(len(pairs)); err != nil {
+		return err
+	}
+
+	for _, pair := range pairs {
+		var (
+			key   K
+			value V
+		)
+
+		if err := json.Unmarshal(pair[0], &key); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(pair[1], &value); err != nil {
+			return err
+		}
+
+		if err := t.set(key, value); err != nil {
+			return err
+		}
+	}
+
+	sm.table = t
+
+	return nil
+}
+
+// countingWriter counts the bytes written to the underlying writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+
+	return n, err
+}
+
+// countingReader counts the bytes read from the underlying reader,
+// and feeds them to crc if set.
+// It implements io.ByteReader so that gob reads exactly what it needs
+// instead of wrapping the reader into a bufio.Reader and reading ahead.
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	crc hash.Hash32
+	buf [1]byte
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	if cr.crc != nil {
+		cr.crc.Write(p[:n])
+	}
+
+	return n, err
+}
+
+func (cr *countingReader) ReadByte() (byte, error) {
+	if _, err := io.ReadFull(cr, cr.buf[:]); err != nil {
+		return 0, err
+	}
+
+	return cr.buf[0], nil
+}
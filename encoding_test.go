@@ -0,0 +1,451 @@
+package stablemap
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"io"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStableMap_Gob(t *testing.T) {
+	sm := New[string, int](64)
+	for i := range 40 {
+		require.NoError(t, sm.Set(strconv.Itoa(i), i))
+	}
+
+	// Leave a few tombstones behind, they must not be encoded
+	for i := range 5 {
+		require.True(t, sm.Delete(strconv.Itoa(i)))
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, gob.NewEncoder(&buf).Encode(sm))
+
+	var decoded StableMap[string, int]
+	require.NoError(t, gob.NewDecoder(&buf).Decode(&decoded))
+
+	assert.Equal(t, 35, decoded.Stats().Size)
+	assert.Equal(t, 0, decoded.Stats().Tombstones)
+
+	for i := range 40 {
+		v, ok := decoded.Get(strconv.Itoa(i))
+		if i < 5 {
+			assert.False(t, ok)
+			continue
+		}
+
+		require.True(t, ok)
+		assert.Equal(t, i, v)
+	}
+}
+
+func TestStableMap_Gob_Set(t *testing.T) {
+	set := New[int, struct{}](1024)
+	for i := range 500 {
+		require.NoError(t, set.Set(i*7, struct{}{}))
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, gob.NewEncoder(&buf).Encode(set))
+
+	decoded := New[int, struct{}](0)
+	require.NoError(t, gob.NewDecoder(&buf).Decode(decoded))
+
+	assert.Equal(t, 500, decoded.Stats().Size)
+	// Sized from the encoded count, not from the source capacity
+	assert.GreaterOrEqual(t, decoded.Stats().EffectiveCapacity, 500)
+
+	for i := range 500 {
+		_, ok := decoded.Get(i * 7)
+		require.Truef(t, ok, "missing %d after decode", i*7)
+	}
+}
+
+func TestStableMap_GobDecode_Invalid(t *testing.T) {
+	sm := New[int, int](16)
+	require.NoError(t, sm.Set(1, 1))
+
+	err := sm.GobDecode([]byte("garbage"))
+	require.Error(t, err)
+
+	// The map is left untouched on error
+	v, ok := sm.Get(1)
+	require.True(t, ok)
+	assert.Equal(t, 1, v)
+}
+
+func TestStableMap_Decode_KeepsOptions(t *testing.T) {
+	sm := New[int, int](64)
+	for i := range 40 {
+		require.NoError(t, sm.Set(i, i))
+	}
+
+	gobData, err := sm.GobEncode()
+	require.NoError(t, err)
+	binaryData, err := sm.MarshalBinary()
+	require.NoError(t, err)
+	jsonData, err := sm.MarshalJSON()
+	require.NoError(t, err)
+
+	decoders := map[string]func(*StableMap[int, int]) error{
+		"gob":    func(m *StableMap[int, int]) error { return m.GobDecode(gobData) },
+		"binary": func(m *StableMap[int, int]) error { return m.UnmarshalBinary(binaryData) },
+		"json":   func(m *StableMap[int, int]) error { return m.UnmarshalJSON(jsonData) },
+	}
+
+	for name, decode := range decoders {
+		t.Run(name, func(t *testing.T) {
+			decoded := New(8,
+				WithLoadFactor[int, int](0.5),
+				WithMaxProbe[int, int](3),
+				WithBackshiftDelete[int, int](),
+			)
+			require.NoError(t, decode(decoded))
+
+			assert.Equal(t, 40, decoded.Len())
+			assert.Equal(t, 0.5, decoded.loadFactor)
+			assert.Equal(t, uintptr(3), decoded.maxProbe)
+			assert.True(t, decoded.backshiftDelete)
+			require.NoError(t, decoded.CheckInvariants())
+		})
+	}
+}
+
+func TestStableSet_Gob(t *testing.T) {
+	ss := NewSet[int](64)
+	for i := range 40 {
+		require.NoError(t, ss.Put(i))
+	}
+	require.True(t, ss.Delete(0))
+
+	var buf bytes.Buffer
+	require.NoError(t, gob.NewEncoder(&buf).Encode(ss))
+	data := bytes.Clone(buf.Bytes())
+
+	var decoded StableSet[int]
+	require.NoError(t, gob.NewDecoder(&buf).Decode(&decoded))
+	assert.Equal(t, 39, decoded.Len())
+	for i := range 40 {
+		assert.Equal(t, i != 0, decoded.Has(i))
+	}
+
+	// Encoded like a map with struct{} values
+	var asMap StableMap[int, struct{}]
+	require.NoError(t, gob.NewDecoder(bytes.NewReader(data)).Decode(&asMap))
+	assert.Equal(t, 39, asMap.Len())
+
+	// The set is left untouched on error
+	require.Error(t, decoded.GobDecode([]byte("garbage")))
+	assert.Equal(t, 39, decoded.Len())
+}
+
+func TestStableMap_JSON_StringKeys(t *testing.T) {
+	sm := New[string, int](16)
+	require.NoError(t, sm.Set("b", 2))
+	require.NoError(t, sm.Set("a", 1))
+	require.NoError(t, sm.Set("c", 3))
+	require.NoError(t, sm.Set("deleted", 4))
+	require.True(t, sm.Delete("deleted"))
+
+	data, err := json.Marshal(sm)
+	require.NoError(t, err)
+	// Object keys are sorted, so the output is stable
+	assert.Equal(t, `{"a":1,"b":2,"c":3}`, string(data))
+
+	var decoded StableMap[string, int]
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, 3, decoded.Stats().Size)
+
+	for key, want := range map[string]int{"a": 1, "b": 2, "c": 3} {
+		v, ok := decoded.Get(key)
+		require.True(t, ok)
+		assert.Equal(t, want, v)
+	}
+}
+
+func TestStableMap_JSON_PairArray(t *testing.T) {
+	sm := New[int, string](16)
+	require.NoError(t, sm.Set(1, "one"))
+	require.NoError(t, sm.Set(2, "two"))
+	require.NoError(t, sm.Set(3, "three"))
+	require.True(t, sm.Delete(3))
+
+	data, err := json.Marshal(sm)
+	require.NoError(t, err)
+
+	var pairs [][2]any
+	require.NoError(t, json.Unmarshal(data, &pairs))
+	assert.ElementsMatch(t, [][2]any{{float64(1), "one"}, {float64(2), "two"}}, pairs)
+
+	var decoded StableMap[int, string]
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, 2, decoded.Stats().Size)
+
+	v, ok := decoded.Get(1)
+	require.True(t, ok)
+	assert.Equal(t, "one", v)
+
+	v, ok = decoded.Get(2)
+	require.True(t, ok)
+	assert.Equal(t, "two", v)
+
+	_, ok = decoded.Get(3)
+	assert.False(t, ok)
+}
+
+func TestStableMap_WriteTo_ReadFrom(t *testing.T) {
+	sm := New[string, int](256)
+	for i := range 200 {
+		require.NoError(t, sm.Set(strconv.Itoa(i), i))
+	}
+	for i := range 20 {
+		require.True(t, sm.Delete(strconv.Itoa(i)))
+	}
+
+	var buf bytes.Buffer
+	written, err := sm.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), written)
+
+	// Trailing data must not be consumed by ReadFrom
+	buf.WriteString("trailer")
+
+	var decoded StableMap[string, int]
+	read, err := decoded.ReadFrom(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, written, read)
+	assert.Equal(t, "trailer", buf.String())
+
+	assert.Equal(t, 180, decoded.Stats().Size)
+	for i := 20; i < 200; i++ {
+		v, ok := decoded.Get(strconv.Itoa(i))
+		require.True(t, ok)
+		assert.Equal(t, i, v)
+	}
+}
+
+// chunkWriter records the size of the largest single write.
+type chunkWriter struct {
+	w        *io.PipeWriter
+	maxChunk int
+}
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	cw.maxChunk = max(cw.maxChunk, len(p))
+	return cw.w.Write(p)
+}
+
+func TestStableMap_WriteTo_ReadFrom_Pipe(t *testing.T) {
+	const n = 10000
+
+	sm := New[int, int](n * 2)
+	for i := range n {
+		require.NoError(t, sm.Set(i, i*i))
+	}
+
+	pr, pw := io.Pipe()
+	cw := &chunkWriter{w: pw}
+
+	var written int64
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		written, err = sm.WriteTo(cw)
+		done <- pw.CloseWithError(err)
+	}()
+
+	var decoded StableMap[int, int]
+	read, err := decoded.ReadFrom(pr)
+	require.NoError(t, err)
+	require.NoError(t, <-done)
+	assert.Equal(t, written, read)
+
+	// The map was streamed in small pieces rather than written in one go
+	assert.Less(t, int64(cw.maxChunk), written/100)
+
+	assert.Equal(t, n, decoded.Stats().Size)
+	for i := range n {
+		v, ok := decoded.Get(i)
+		require.True(t, ok)
+		assert.Equal(t, i*i, v)
+	}
+}
+
+func TestStableMap_MarshalBinary(t *testing.T) {
+	sm := New[int, int](64)
+	for i := range 40 {
+		require.NoError(t, sm.Set(i, i*2))
+	}
+
+	data, err := sm.MarshalBinary()
+	require.NoError(t, err)
+
+	var decoded StableMap[int, int]
+	require.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, 40, decoded.Len())
+	for i := range 40 {
+		v, ok := decoded.Get(i)
+		require.True(t, ok)
+		assert.Equal(t, i*2, v)
+	}
+
+	require.Error(t, decoded.UnmarshalBinary(append(data, 0)))
+	assert.Equal(t, 40, decoded.Len())
+}
+
+func TestStableMap_UnmarshalBinary_Corrupted(t *testing.T) {
+	sm := New[int, int](64)
+	for i := range 40 {
+		require.NoError(t, sm.Set(i, i))
+	}
+
+	data, err := sm.MarshalBinary()
+	require.NoError(t, err)
+
+	// Flip a bit in a value at the end of the payload, which gob still decodes fine
+	data[len(data)-5] ^= 0x02
+
+	decoded := New[int, int](8)
+	require.NoError(t, decoded.Set(1, 1))
+
+	err = decoded.UnmarshalBinary(data)
+	require.ErrorIs(t, err, ErrChecksumMismatch)
+
+	// The map is left untouched
+	assert.Equal(t, 1, decoded.Len())
+	v, ok := decoded.Get(1)
+	require.True(t, ok)
+	assert.Equal(t, 1, v)
+}
+
+func TestStableMap_UnmarshalBinary_CorruptedCount(t *testing.T) {
+	sm := New[int, int](64)
+	for i := range 40 {
+		require.NoError(t, sm.Set(i, i))
+	}
+
+	data, err := sm.MarshalBinary()
+	require.NoError(t, err)
+
+	decoded := New[int, int](8)
+	require.NoError(t, decoded.Set(1, 1))
+
+	// The count is checked before anything is allocated for it, a flip in its
+	// high byte is rejected before the checksum is even read
+	highByte := bytes.Clone(data)
+	highByte[12] ^= 0x01
+	require.ErrorIs(t, decoded.UnmarshalBinary(highByte), ErrInvalidFormat)
+
+	// Fewer entries than written: the rest of the payload is taken for the checksum
+	lowBit := bytes.Clone(data)
+	lowBit[5] ^= 0x08
+	require.ErrorIs(t, decoded.UnmarshalBinary(lowBit), ErrChecksumMismatch)
+
+	// More entries than written: the checksum is decoded as an entry
+	moreEntries := bytes.Clone(data)
+	moreEntries[5] ^= 0x02
+	require.Error(t, decoded.UnmarshalBinary(moreEntries))
+
+	// The map is left untouched
+	assert.Equal(t, 1, decoded.Len())
+}
+
+func TestStableMap_UnmarshalBinary_Version1(t *testing.T) {
+	// Version 1: magic, version, count, gob entries and no checksum
+	var buf bytes.Buffer
+	buf.WriteString("SMAP")
+	buf.WriteByte(1)
+	buf.Write([]byte{3, 0, 0, 0, 0, 0, 0, 0})
+
+	enc := gob.NewEncoder(&buf)
+	for i := range 3 {
+		require.NoError(t, enc.Encode(strconv.Itoa(i)))
+		require.NoError(t, enc.Encode(i*10))
+	}
+
+	var decoded StableMap[string, int]
+	require.NoError(t, decoded.UnmarshalBinary(buf.Bytes()))
+	assert.Equal(t, 3, decoded.Len())
+	for i := range 3 {
+		v, ok := decoded.Get(strconv.Itoa(i))
+		require.True(t, ok)
+		assert.Equal(t, i*10, v)
+	}
+}
+
+func TestStableMap_UnmarshalBinary_InvalidHeader(t *testing.T) {
+	sm := New[int, int](8)
+	require.NoError(t, sm.Set(1, 1))
+
+	data, err := sm.MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("SMAP\x02"), data[:5])
+
+	var decoded StableMap[int, int]
+
+	wrongMagic := bytes.Clone(data)
+	copy(wrongMagic, "PAMS")
+	require.ErrorIs(t, decoded.UnmarshalBinary(wrongMagic), ErrInvalidFormat)
+
+	newerVersion := bytes.Clone(data)
+	newerVersion[4] = 3
+	require.ErrorIs(t, decoded.UnmarshalBinary(newerVersion), ErrInvalidFormat)
+
+	require.Error(t, decoded.UnmarshalBinary(data[:3]))
+	assert.Equal(t, 0, decoded.Len())
+}
+
+func TestStableMap_Decode_HugeCount(t *testing.T) {
+	// A header claiming more entries than any map holds, without any entry
+	var header bytes.Buffer
+	header.WriteString("SMAP\x02")
+	header.Write([]byte{0, 0, 0, 0, 2, 0, 0, 0}) // 1<<33
+
+	decoded := New[int, int](8)
+	require.NoError(t, decoded.Set(1, 1))
+
+	require.ErrorIs(t, decoded.UnmarshalBinary(header.Bytes()), ErrInvalidFormat)
+
+	_, err := decoded.ReadFrom(bytes.NewReader(header.Bytes()))
+	require.ErrorIs(t, err, ErrInvalidFormat)
+
+	var gobData bytes.Buffer
+	require.NoError(t, gob.NewEncoder(&gobData).Encode(uint64(1<<33)))
+	require.ErrorIs(t, decoded.GobDecode(gobData.Bytes()), ErrInvalidFormat)
+
+	// A count within the bounds isn't allocated for up front, the entries run out first
+	var truncated bytes.Buffer
+	truncated.WriteString("SMAP\x02")
+	truncated.Write([]byte{0, 0, 0, 0x40, 0, 0, 0, 0}) // 1<<30
+	require.ErrorIs(t, decoded.UnmarshalBinary(truncated.Bytes()), io.EOF)
+
+	// The map is left untouched
+	assert.Equal(t, 1, decoded.Len())
+}
+
+func TestStableMap_Decode_GrowsPastPrealloc(t *testing.T) {
+	n := decodePrealloc + 1000
+
+	sm := New[int, int](CapacityForElements(n))
+	for i := range n {
+		require.NoError(t, sm.Set(i, i))
+	}
+
+	data, err := sm.MarshalBinary()
+	require.NoError(t, err)
+
+	var decoded StableMap[int, int]
+	require.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, n, decoded.Len())
+	for i := range n {
+		v, ok := decoded.Get(i)
+		require.True(t, ok)
+		require.Equal(t, i, v)
+	}
+	require.NoError(t, decoded.CheckInvariants())
+}
@@ -3,20 +3,22 @@ package stablemap
 const groupSize = 8
 
 type group[K comparable, V any] struct {
+	// 8 values stored first.
+	// The Go compiler pads a zero-sized field at the end of a struct, so that
+	// a pointer to it doesn't point past the struct. Stored last, a [8]struct{}
+	// array would cost a whole word per group for maps used as sets.
+	// Stored first, it takes no room at all, and for other value types the
+	// metadata and the keys still follow each other.
+	// Be careful with the value type nevertheless: if it's too large, the values
+	// of a group span many cache lines.
+	values [groupSize]V
+
 	// 8 bytes of metadata (h2 or control states)
 	// This fits perfectly in a single uint64 load
 	ctrls [groupSize]uint8
 
 	// 8 keys stored immediately after the metadata
-	// In a 64-bit system, this group is (8 + 8*8) = 72 bytes.
+	// In a 64-bit system, this is (8 + 8*8) = 72 bytes.
 	// That's just slightly over one 64-byte cache line.
 	slots [groupSize]K
-
-	// 8 values stored after the keys.
-	// Even If V is a struct{} type, Go compiler will add padding.
-	// It's very sensible, you need to be careful with the value type.
-	// If it's too large, you'll end up missing CPU cache lines.
-	// If it's too low, Go will add padding and you'll end up wasting
-	// memory for nothing.
-	values [groupSize]V
 }
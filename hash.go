@@ -16,3 +16,31 @@ func HashSplit(hash uint64) (uintptr, uint8) {
 
 	return h1, h2
 }
+
+// HashUint64 is a cheap hash function for uint64 keys, to be used via WithHashFunc
+// instead of the default maphash-based one.
+// It's the SplitMix64 finalizer: two multiply-xorshift rounds, enough for every key bit
+// to affect both h1 and h2, so sequential and strided keys spread evenly.
+// Unlike the default hash function it isn't seeded, so it offers no protection
+// against adversarially chosen keys.
+func HashUint64(key uint64) uint64 {
+	key ^= key >> 30
+	key *= 0xBF58476D1CE4E5B9
+	key ^= key >> 27
+	key *= 0x94D049BB133111EB
+	key ^= key >> 31
+
+	return key
+}
+
+// HashUint32 is a cheap hash function for uint32 keys, to be used via WithHashFunc
+// instead of the default maphash-based one.
+// A single multiplication by a 64-bit odd constant spreads the key over the upper half,
+// which is then folded into the lower half, so every key bit affects both h1 and h2.
+// It's half the work of HashUint64, which has to mix twice as many input bits.
+// Like HashUint64, it isn't seeded.
+func HashUint32(key uint32) uint64 {
+	h := uint64(key) * 0x9E3779B97F4A7C15
+
+	return h ^ h>>32
+}
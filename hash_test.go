@@ -65,3 +65,62 @@ func TestHashSplit(t *testing.T) {
 		})
 	}
 }
+
+func TestHash_Distribution(t *testing.T) {
+	tests := []struct {
+		name string
+		hash func(uint64) uint64
+		// The key sets, each within the width of the hashed integer
+		keys map[string]func(i uint64) uint64
+	}{
+		{
+			name: "HashUint64",
+			hash: HashUint64,
+			keys: map[string]func(i uint64) uint64{
+				"sequential": func(i uint64) uint64 { return i },
+				"strided":    func(i uint64) uint64 { return i << 12 },
+				"high bits":  func(i uint64) uint64 { return i << 40 },
+			},
+		},
+		{
+			name: "HashUint32",
+			hash: func(k uint64) uint64 { return HashUint32(uint32(k)) },
+			keys: map[string]func(i uint64) uint64{
+				"sequential": func(i uint64) uint64 { return i },
+				"strided":    func(i uint64) uint64 { return i << 8 },
+				"high bits":  func(i uint64) uint64 { return i << 16 },
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		for name, key := range tc.keys {
+			t.Run(tc.name+"/"+name, func(t *testing.T) {
+				tt := newTable(1<<16, WithHashFunc[uint64, struct{}](tc.hash))
+				effectiveCapacity := tt.Stats().EffectiveCapacity
+
+				var h2Counts [128]int
+				for i := range uint64(effectiveCapacity) {
+					require.NoError(t, tt.set(key(i), struct{}{}))
+
+					_, h2 := HashSplit(tc.hash(key(i)))
+					h2Counts[h2]++
+				}
+
+				// Every h2 value shows up within 25% of the average
+				avg := effectiveCapacity / len(h2Counts)
+				for h2, count := range h2Counts {
+					require.InDeltaf(t, avg, count, float64(avg)/4, "h2 0x%02X is skewed", h2)
+				}
+
+				// No pathological clustering: the worst probe is in the same range as maphash's
+				reference := newTable[uint64, struct{}](1 << 16)
+				for i := range uint64(effectiveCapacity) {
+					require.NoError(t, reference.set(key(i), struct{}{}))
+				}
+
+				require.LessOrEqual(t, maxProbeLength(tt), 2*maxProbeLength(reference))
+			})
+		}
+	}
+}
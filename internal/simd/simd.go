@@ -0,0 +1,31 @@
+// Package simd holds the SSE2 experiment for matching stablemap's control bytes.
+//
+// stablemap doesn't use it: Go can't inline assembly, and for a single 8-byte group
+// the call overhead eats the gain over the SWAR matchers in bits.go. The package
+// keeps the assembly, its cross-check and its benchmark out of the shipped one.
+package simd
+
+const (
+	bitsetLSB = 0x0101010101010101
+	bitsetMSB = 0x8080808080808080
+
+	groupSize = 8
+
+	slotEmpty   = 0x00
+	slotDeleted = 0x7E
+	slotFull    = 0x80
+)
+
+// bitset mirrors stablemap's bitset: 0x80 in each byte of a matching slot.
+type bitset uint64
+
+// matchH2 is a copy of stablemap's SWAR matchH2, the baseline for the benchmark.
+func matchH2(group uint64, h2 uint8) bitset {
+	v := group ^ (bitsetLSB * uint64(h2|slotFull))
+	return bitset(((v - bitsetLSB) &^ v) & bitsetMSB)
+}
+
+// matchEmpty is a copy of stablemap's SWAR matchEmpty.
+func matchEmpty(group uint64) bitset {
+	return bitset(^(group | (group << 1)) & bitsetMSB)
+}
@@ -0,0 +1,15 @@
+//go:build !purego
+
+package simd
+
+// SSE2 is part of the amd64 baseline, so these need no runtime CPU feature detection.
+//
+// They are exact byte-wise compares (PCMPEQB), unlike the SWAR matchH2 which may report
+// false positives. In isolation (BenchmarkMatchH2) both run at ~2.6ns, but switching
+// stablemap's get to matchH2SSE slowed BenchmarkStableMap_Get from ~11.6ns to ~13.2ns.
+
+// matchH2SSE is matchH2 implemented with SSE2.
+func matchH2SSE(group uint64, h2 uint8) bitset
+
+// matchEmptySSE is matchEmpty implemented with SSE2.
+func matchEmptySSE(group uint64) bitset
@@ -0,0 +1,29 @@
+//go:build !purego
+
+#include "textflag.h"
+
+// func matchH2SSE(group uint64, h2 uint8) bitset
+TEXT ·matchH2SSE(SB), NOSPLIT, $0-24
+	MOVQ    group+0(FP), X0
+	MOVBQZX h2+8(FP), AX
+	ORQ     $0x80, AX
+	MOVQ    $0x0101010101010101, BX
+	IMULQ   BX, AX
+	MOVQ    AX, X1
+	PCMPEQB X1, X0
+	MOVQ    X0, AX
+	MOVQ    $0x8080808080808080, BX
+	ANDQ    BX, AX
+	MOVQ    AX, ret+16(FP)
+	RET
+
+// func matchEmptySSE(group uint64) bitset
+TEXT ·matchEmptySSE(SB), NOSPLIT, $0-16
+	MOVQ    group+0(FP), X0
+	PXOR    X1, X1
+	PCMPEQB X1, X0
+	MOVQ    X0, AX
+	MOVQ    $0x8080808080808080, BX
+	ANDQ    BX, AX
+	MOVQ    AX, ret+8(FP)
+	RET
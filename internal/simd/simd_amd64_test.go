@@ -0,0 +1,82 @@
+//go:build !purego
+
+package simd
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// matchByteRef is a byte-by-byte reference implementation of an exact byte match.
+func matchByteRef(group uint64, b uint8) bitset {
+	var result bitset
+	for i := range groupSize {
+		if uint8(group>>(i*8)) == b {
+			result |= bitset(0x80) << (i * 8)
+		}
+	}
+
+	return result
+}
+
+func TestMatchSSE_CrossCheck(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+
+	for range 100000 {
+		ctrl := randomCtrls(rng)
+		h2 := uint8(rng.Uint64N(0x80))
+
+		exact := matchByteRef(ctrl, h2|slotFull)
+		require.Equal(t, exact, matchH2SSE(ctrl, h2), "matchH2SSE(0x%016X, 0x%02X)", ctrl, h2)
+
+		// SWAR may only add false positives on top of the exact matches
+		swar := matchH2(ctrl, h2)
+		require.Equal(t, exact, swar&exact, "matchH2(0x%016X, 0x%02X) missed a match", ctrl, h2)
+
+		require.Equal(t, matchEmpty(ctrl), matchEmptySSE(ctrl), "matchEmpty(0x%016X)", ctrl)
+	}
+}
+
+// randomCtrls returns a group of random control bytes, a quarter of them empty and
+// a quarter deleted.
+func randomCtrls(rng *rand.Rand) uint64 {
+	var ctrl uint64
+	for i := range groupSize {
+		var b uint64
+		switch rng.IntN(4) {
+		case 0:
+			b = slotEmpty
+		case 1:
+			b = slotDeleted
+		default:
+			b = rng.Uint64N(0x80) | slotFull
+		}
+		ctrl |= b << (i * 8)
+	}
+
+	return ctrl
+}
+
+var sinkBitset bitset
+
+func BenchmarkMatchH2(b *testing.B) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	ctrls := make([]uint64, 1024)
+	for i := range ctrls {
+		ctrls[i] = randomCtrls(rng)
+	}
+
+	b.Run("SWAR", func(b *testing.B) {
+		for i := 0; b.Loop(); i++ {
+			sinkBitset |= matchH2(ctrls[i&1023], uint8(i&0x7F))
+		}
+	})
+
+	b.Run("SSE2", func(b *testing.B) {
+		for i := 0; b.Loop(); i++ {
+			sinkBitset |= matchH2SSE(ctrls[i&1023], uint8(i&0x7F))
+		}
+	})
+}
@@ -0,0 +1,241 @@
+package stablemap
+
+import (
+	"hash/maphash"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/require"
+)
+
+// soaTable is an experimental structure-of-arrays layout: control bytes, keys and
+// values live in three parallel slices instead of being interleaved per group.
+// It only supports what's needed to compare lookups against the group layout.
+type soaTable[K comparable, V any] struct {
+	ctrls  []uint8
+	keys   []K
+	values []V
+
+	numGroupsMask uintptr
+	hashFunc      HashFunc[K]
+}
+
+func newSoaTable[K comparable, V any](capacity int) *soaTable[K, V] {
+	normalizedCapacity := normalizeCapacity(capacity)
+
+	t := &soaTable[K, V]{
+		ctrls:         make([]uint8, normalizedCapacity),
+		keys:          make([]K, normalizedCapacity),
+		values:        make([]V, normalizedCapacity),
+		numGroupsMask: normalizedCapacity/groupSize - 1,
+		hashFunc:      MakeDefaultHashFunc[K](maphash.MakeSeed()),
+	}
+
+	for i := range t.ctrls {
+		t.ctrls[i] = slotEmpty
+	}
+
+	return t
+}
+
+func (t *soaTable[K, V]) ctrl(offset uintptr) uint64 {
+	return *(*uint64)(unsafe.Pointer(&t.ctrls[offset*groupSize]))
+}
+
+func (t *soaTable[K, V]) get(key K) (V, bool) {
+	h1, h2 := HashSplit(t.hashFunc(key))
+	mask := t.numGroupsMask
+	start := (h1 / groupSize) & mask
+
+	for p, offset := uintptr(0), start; p <= mask; p++ {
+		ctrl := t.ctrl(offset)
+
+		for matches := matchH2(ctrl, h2); matches != 0; matches = matches.removeFirst() {
+			idx := offset*groupSize + matches.first()
+			if t.keys[idx] == key {
+				return t.values[idx], true
+			}
+		}
+
+		if matchEmpty(ctrl) != 0 {
+			var v V
+			return v, false
+		}
+
+		offset = (start + (p+1)*(p+2)/2) & mask
+	}
+
+	var v V
+	return v, false
+}
+
+// set inserts a new key. It doesn't handle updates or a full table,
+// the benchmarks never need either.
+func (t *soaTable[K, V]) set(key K, value V) {
+	h1, h2 := HashSplit(t.hashFunc(key))
+	mask := t.numGroupsMask
+	start := (h1 / groupSize) & mask
+
+	for p, offset := uintptr(0), start; p <= mask; p++ {
+		if matches := matchEmpty(t.ctrl(offset)); matches != 0 {
+			idx := offset*groupSize + matches.first()
+			t.ctrls[idx] = h2 | slotFull
+			t.keys[idx] = key
+			t.values[idx] = value
+
+			return
+		}
+
+		offset = (start + (p+1)*(p+2)/2) & mask
+	}
+}
+
+func TestSoaTable(t *testing.T) {
+	tt := newSoaTable[uint64, uint64](1024)
+	for i := range uint64(800) {
+		tt.set(i, i*2)
+	}
+
+	for i := range uint64(1000) {
+		v, ok := tt.get(i)
+		if i >= 800 {
+			require.False(t, ok)
+			continue
+		}
+
+		require.True(t, ok)
+		require.Equal(t, i*2, v)
+	}
+}
+
+const layoutBenchCapacity = 1 << 22
+
+func BenchmarkLayout_AoS_Get(b *testing.B) {
+	keys := setupBenchData(layoutBenchCapacity * 3 / 4)
+	sm := New[uint64, uint64](layoutBenchCapacity)
+	for _, k := range keys {
+		_ = sm.Set(k, k)
+	}
+
+	for i := 0; b.Loop(); i++ {
+		sm.Get(keys[(i*1337)%len(keys)])
+	}
+}
+
+func BenchmarkLayout_SoA_Get(b *testing.B) {
+	keys := setupBenchData(layoutBenchCapacity * 3 / 4)
+	tt := newSoaTable[uint64, uint64](layoutBenchCapacity)
+	for _, k := range keys {
+		tt.set(k, k)
+	}
+
+	for i := 0; b.Loop(); i++ {
+		tt.get(keys[(i*1337)%len(keys)])
+	}
+}
+
+func BenchmarkLayout_AoS_GetMiss(b *testing.B) {
+	keys := setupBenchData(layoutBenchCapacity * 3 / 4)
+	sm := New[uint64, uint64](layoutBenchCapacity)
+	for _, k := range keys {
+		_ = sm.Set(k, k)
+	}
+
+	for i := 0; b.Loop(); i++ {
+		sm.Get(uint64(i)*1234567 + 1)
+	}
+}
+
+func BenchmarkLayout_SoA_GetMiss(b *testing.B) {
+	keys := setupBenchData(layoutBenchCapacity * 3 / 4)
+	tt := newSoaTable[uint64, uint64](layoutBenchCapacity)
+	for _, k := range keys {
+		tt.set(k, k)
+	}
+
+	for i := 0; b.Loop(); i++ {
+		tt.get(uint64(i)*1234567 + 1)
+	}
+}
+
+// largeValue is a value type of 128 bytes, two cache lines.
+//
+// The benchmarks below decided against a WithExternalValues option. Moving the values
+// out of the groups mostly speeds up membership checks, and callers can already get
+// that layout with an index map, see externalValues.
+type largeValue [16]uint64
+
+// The values live in a slice next to the map, which only stores their index:
+// the groups hold 8 bytes of control bytes, 64 of keys and 32 of indices,
+// instead of 1024 bytes of values.
+type externalValues struct {
+	index  *StableMap[uint64, uint32]
+	values []largeValue
+}
+
+func (ev *externalValues) get(key uint64) (largeValue, bool) {
+	i, ok := ev.index.Get(key)
+	if !ok {
+		return largeValue{}, false
+	}
+
+	return ev.values[i], true
+}
+
+const largeValueBenchCapacity = 1 << 18
+
+func BenchmarkLayout_InlineValues_Get(b *testing.B) {
+	keys := setupBenchData(largeValueBenchCapacity * 3 / 4)
+	sm := New[uint64, largeValue](largeValueBenchCapacity)
+	for _, k := range keys {
+		_ = sm.Set(k, largeValue{k})
+	}
+
+	var sink uint64
+	for i := 0; b.Loop(); i++ {
+		v, _ := sm.Get(keys[(i*1337)%len(keys)])
+		sink += v[0]
+	}
+	_ = sink
+}
+
+func BenchmarkLayout_ExternalValues_Get(b *testing.B) {
+	keys := setupBenchData(largeValueBenchCapacity * 3 / 4)
+	ev := externalValues{index: New[uint64, uint32](largeValueBenchCapacity)}
+	for _, k := range keys {
+		_ = ev.index.Set(k, uint32(len(ev.values)))
+		ev.values = append(ev.values, largeValue{k})
+	}
+
+	var sink uint64
+	for i := 0; b.Loop(); i++ {
+		v, _ := ev.get(keys[(i*1337)%len(keys)])
+		sink += v[0]
+	}
+	_ = sink
+}
+
+func BenchmarkLayout_InlineValues_Contains(b *testing.B) {
+	keys := setupBenchData(largeValueBenchCapacity * 3 / 4)
+	sm := New[uint64, largeValue](largeValueBenchCapacity)
+	for _, k := range keys {
+		_ = sm.Set(k, largeValue{k})
+	}
+
+	for i := 0; b.Loop(); i++ {
+		sm.Contains(keys[(i*1337)%len(keys)])
+	}
+}
+
+func BenchmarkLayout_ExternalValues_Contains(b *testing.B) {
+	keys := setupBenchData(largeValueBenchCapacity * 3 / 4)
+	ev := externalValues{index: New[uint64, uint32](largeValueBenchCapacity)}
+	for _, k := range keys {
+		_ = ev.index.Set(k, uint32(len(ev.values)))
+		ev.values = append(ev.values, largeValue{k})
+	}
+
+	for i := 0; b.Loop(); i++ {
+		ev.index.Contains(keys[(i*1337)%len(keys)])
+	}
+}
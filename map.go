@@ -1,10 +1,17 @@
 package stablemap
 
+import (
+	"cmp"
+	"iter"
+	"slices"
+	"unsafe"
+)
+
 // StableMap is a map-like data structure, which uses swiss-tables under the hood.
 // It's stable, because it's designed to never grow up - it retains the capacity
 // it was initialized with. This is especially helpful for a large sets in memory.
-// Since we're going to use swiss table rehashing, it's not safe to iter over the set,
-// and the iteration API is not provided.
+// Since we're going to use swiss table rehashing, it's not safe to modify the map
+// while iterating over it with All.
 //
 // StableMap is NOT safe for concurrent use. If multiple goroutines access a StableMap
 // concurrently, and at least one of them modifies it, external synchronization is required.
@@ -13,6 +20,9 @@ type StableMap[K comparable, V any] struct {
 }
 
 // Returns a new instance of the stable map.
+// The capacity is rounded up to the next power of 2, and up to one group (8 slots) at least.
+// Only 7/8 of it can be filled by default, see WithLoadFactor: New(1000) gets 1024 slots,
+// 896 of which are usable. The actual numbers are reported by Stats.
 func New[K comparable, V any](capacity int, opts ...Option[K, V]) *StableMap[K, V] {
 	var sm StableMap[K, V]
 	sm.init(capacity, opts...)
@@ -20,11 +30,126 @@ func New[K comparable, V any](capacity int, opts ...Option[K, V]) *StableMap[K,
 	return &sm
 }
 
+// Returns a new instance of the stable map like New, but validates the capacity first,
+// e.g. when it comes from untrusted configuration. Returns an error wrapping
+// ErrInvalidCapacity if it isn't positive, exceeds 2^31 slots, or if the groups
+// storage would be larger than the Go runtime can allocate. Under WithStrictValueSize,
+// returns an error wrapping ErrValueTooLarge for a value type larger than 64 bytes.
+// WithInitialData errors are returned as well, rather than panicking.
+func TryNew[K comparable, V any](capacity int, opts ...Option[K, V]) (*StableMap[K, V], error) {
+	if err := validateCapacity[K, V](capacity); err != nil {
+		return nil, err
+	}
+
+	var sm StableMap[K, V]
+	sm.configure(opts...)
+
+	if err := sm.checkValueSize(); err != nil {
+		return nil, err
+	}
+
+	if err := sm.create(capacity); err != nil {
+		return nil, err
+	}
+
+	return &sm, nil
+}
+
+// Returns a new map with the keys of `src` and the values produced by `fn` for each entry.
+// The new map has the same capacity and options as `src`.
+func MapValues[K comparable, V1, V2 any](src *StableMap[K, V1], fn func(key K, value V1) V2) (*StableMap[K, V2], error) {
+	var (
+		dst StableMap[K, V2]
+		err error
+	)
+
+	dst.init(int(src.capacity), optionsOf[K, V1, V2](&src.table)...)
+
+	src.all(func(key K, value V1) bool {
+		err = dst.set(key, fn(key, value))
+		return err == nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &dst, nil
+}
+
 // Checks whether a key is in the map.
 func (sm *StableMap[K, V]) Get(key K) (V, bool) {
 	return sm.get(key)
 }
 
+// Returns the value stored for the key, or `fallback` if the key is missing.
+// Nothing is inserted on a miss.
+func (sm *StableMap[K, V]) GetOr(key K, fallback V) V {
+	if value, ok := sm.get(key); ok {
+		return value
+	}
+
+	return fallback
+}
+
+// Returns the value like Get, along with the number of groups (of 8 slots each)
+// visited to find the key, or to conclude it's missing. 1 means the key was found in
+// its home group. Keys that take many probes are worth caching elsewhere, or
+// a hint that the map needs compacting or a better hash function.
+func (sm *StableMap[K, V]) GetWithProbes(key K) (V, bool, int) {
+	return sm.getWithProbes(key, sm.hashFunc(key))
+}
+
+// Checks whether a key is in the map, without copying its value out like Get does.
+// Prefer it for membership checks on maps with large values.
+func (sm *StableMap[K, V]) Contains(key K) bool {
+	_, _, ok := sm.find(key, sm.hashFunc(key))
+	return ok
+}
+
+// Returns the key stored in the map that is equal to `key`.
+// Equal keys may still differ: two equal strings may have different backing memory,
+// and +0.0 is equal to -0.0. Returning the stored one allows interning, e.g. keeping
+// a single copy of each distinct string alive.
+func (sm *StableMap[K, V]) FindKey(key K) (K, bool) {
+	g, idx, ok := sm.find(key, sm.hashFunc(key))
+	if !ok {
+		var zero K
+		return zero, false
+	}
+
+	return g.slots[idx], true
+}
+
+// Returns a pointer to the value stored for the key, to update it in place
+// without copying it in and out of the map.
+//
+// WARNING: the pointer refers to the map's internal storage. It's only valid until
+// the next call modifying the map: Delete, Set of another key, Compact, Grow, Trim,
+// Reset, etc. may move the entry or reuse its slot. Writing through a stale pointer
+// silently corrupts another entry.
+func (sm *StableMap[K, V]) GetPtr(key K) (*V, bool) {
+	g, idx, ok := sm.find(key, sm.hashFunc(key))
+	if !ok {
+		return nil, false
+	}
+
+	return &g.values[idx], true
+}
+
+// Looks up a batch of keys.
+// values[i] and found[i] hold the result for keys[i], as if returned by Get.
+// Consecutive lookups are pipelined to overlap their memory latency,
+// which pays off for large maps that don't fit into the CPU cache.
+func (sm *StableMap[K, V]) GetMany(keys []K) (values []V, found []bool) {
+	values = make([]V, len(keys))
+	found = make([]bool, len(keys))
+
+	sm.getMany(keys, values, found)
+
+	return values, found
+}
+
 // Sets a key in the map.
 // If the key is already present, overwrites it.
 // Returns an error if the table is full.
@@ -32,7 +157,386 @@ func (sm *StableMap[K, V]) Set(key K, value V) error {
 	return sm.set(key, value)
 }
 
+// Sets a key in the map like Set, and returns the value it replaced.
+// loaded is true if the key was present, otherwise previous is the zero value.
+// Returns an error if the key isn't present and the table is full.
+func (sm *StableMap[K, V]) Swap(key K, value V) (previous V, loaded bool, err error) {
+	hash := sm.hashFunc(key)
+
+	// find leaves a pending compaction as it is, writes complete it first
+	sm.completeCompaction()
+
+	if g, idx, ok := sm.find(key, hash); ok {
+		previous = g.values[idx]
+		g.values[idx] = value
+		sm.mutated(1)
+
+		return previous, true, nil
+	}
+
+	return sm.emptyV, false, sm.setHashed(key, hash, value)
+}
+
+// Sets the key to `new` only if it's present and its value is equal to `old` per `eq`,
+// like sync.Map.CompareAndSwap. Returns whether the value was swapped.
+func (sm *StableMap[K, V]) CompareAndSwap(key K, old, new V, eq func(a, b V) bool) bool {
+	sm.completeCompaction()
+
+	g, idx, ok := sm.find(key, sm.hashFunc(key))
+	if !ok || !eq(g.values[idx], old) {
+		return false
+	}
+
+	g.values[idx] = new
+	sm.mutated(1)
+
+	return true
+}
+
+// Sets a key in the map like Set, with the key's hash computed by the caller,
+// e.g. once upfront for a key used in several lookups.
+// The hash must be the one the map's hash function returns for the key
+// (see WithHashFunc), otherwise the entry is lost to Get and Delete.
+func (sm *StableMap[K, V]) SetHashed(key K, hash uint64, value V) error {
+	return sm.setHashed(key, hash, value)
+}
+
+// Checks whether a key is in the map like Get, with the key's hash computed by the caller.
+// The hash must be the one the map's hash function returns for the key.
+func (sm *StableMap[K, V]) GetHashed(key K, hash uint64) (V, bool) {
+	return sm.getHashed(key, hash)
+}
+
 // Deletes a key from the map.
 func (sm *StableMap[K, V]) Delete(key K) bool {
 	return sm.delete(key)
 }
+
+// Deletes the key only if its value is equal to `expected` per `eq`,
+// like sync.Map.CompareAndDelete. Returns whether the key was deleted.
+func (sm *StableMap[K, V]) CompareAndDelete(key K, expected V, eq func(a, b V) bool) bool {
+	sm.completeCompaction()
+
+	g, idx, ok := sm.find(key, sm.hashFunc(key))
+	if !ok || !eq(g.values[idx], expected) {
+		return false
+	}
+
+	sm.deleteAt(g, idx)
+
+	return true
+}
+
+// Sets every key to the value at the same index, overwriting present keys.
+// Returns the number of keys set. If the table fills up, it stops at the first key
+// that doesn't fit and returns ErrTableFull along with the number of keys set so far.
+// Returns ErrLengthMismatch without setting anything if the slices' lengths differ.
+func (sm *StableMap[K, V]) SetMany(keys []K, values []V) (int, error) {
+	if len(keys) != len(values) {
+		return 0, ErrLengthMismatch
+	}
+
+	return sm.setMany(keys, values)
+}
+
+// Sets every key of `m` to its value, overwriting present keys, e.g. to apply a delta.
+// Returns the number of keys set. If the table fills up, it stops at the first key
+// that doesn't fit and returns ErrTableFull along with the number of keys set so far.
+// Since a Go map is walked in random order, which of them were set is random as well.
+func (sm *StableMap[K, V]) SetFromMap(m map[K]V) (int, error) {
+	var n int
+	for key, value := range m {
+		if err := sm.set(key, value); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+// Deletes every given key from the map.
+// Returns the number of keys that were present.
+func (sm *StableMap[K, V]) DeleteMany(keys []K) int {
+	return sm.deleteMany(keys)
+}
+
+// Deletes every given key from the map like DeleteMany, but compacts the map
+// in the middle of the batch as soon as Stats().TombstonesCapacityRatio exceeds
+// `maxTombstoneRatio`, whatever the compaction options. A large sweep then never
+// leaves lookups walking more tombstones than that, and deletes of the keys further
+// in the batch, which miss or cross the tombstones, stay fast as well.
+// Under WithMaxProbe, if the entries don't fit a rebuild, the rest of the batch
+// is deleted without compacting.
+// Returns the number of keys that were present.
+func (sm *StableMap[K, V]) DeleteManyCompacting(keys []K, maxTombstoneRatio float32) int {
+	var (
+		deleted   int
+		compactOK = true
+	)
+
+	for _, key := range keys {
+		if !sm.delete(key) {
+			continue
+		}
+
+		deleted++
+		if compactOK && sm.ShouldCompact(maxTombstoneRatio) {
+			sm.compact()
+			// Under WithMaxProbe the entries may not fit a rebuild, which leaves the
+			// tombstones in place: retrying it for every delete would cost as much
+			compactOK = sm.tombstones == 0
+		}
+	}
+
+	return deleted
+}
+
+// Deletes every entry for which `pred` returns true.
+// Returns the number of deleted entries.
+// Like Delete, each removed entry leaves a tombstone behind. A sweep can create many
+// of them at once, in which case the map is compacted right after it.
+// `pred` must not modify the map.
+func (sm *StableMap[K, V]) DeleteIf(pred func(key K, value V) bool) int {
+	return sm.deleteIf(pred)
+}
+
+// Grows the map to hold at least `newCapacity` slots, rounded up to the next power of 2.
+// Every live entry is migrated into the new storage and tombstones are dropped.
+// It's a no-op if the map is already that large.
+// Returns ErrCapacityTooSmall if `newCapacity` is smaller than the current size,
+// and ErrInvalidCapacity if it exceeds the maximum capacity of 2^31 slots.
+func (sm *StableMap[K, V]) Grow(newCapacity int) error {
+	return sm.grow(newCapacity)
+}
+
+// Removes every entry like Reset, and also zeroes the keys and values left behind
+// in the map's storage, as well as tombstoned ones. Use it when keys or values hold
+// secrets that must not linger in memory, or to let the garbage collector reclaim
+// what they reference.
+// Reset only clears the control bytes, 1 byte per slot, Purge writes over the whole
+// storage: it costs as much as allocating the map again, and touches every page of it.
+// Copies made before, e.g. by Snapshot or Get, are left as they are.
+func (sm *StableMap[K, V]) Purge() {
+	// Zero control bytes are empty slots
+	clear(sm.groups)
+	clear(sm.hashes)
+	sm.resetCounters()
+}
+
+// Ensures the map can take `additional` more entries without returning ErrTableFull,
+// growing it ahead of a known bulk insert if needed. It's a no-op if they already fit.
+// Returns ErrInvalidCapacity if they can't fit under the load factor in 2^31 slots.
+func (sm *StableMap[K, V]) Reserve(additional int) error {
+	return sm.reserve(additional)
+}
+
+// Shrinks the map to `targetCapacity` slots, rounded up to the next power of 2, to
+// reclaim memory after bulk deletion. The map is never shrunk below the capacity needed
+// to hold its current entries under the load factor.
+// Every live entry is migrated into the new storage and tombstones are dropped.
+// It's a no-op if the map is already that small.
+func (sm *StableMap[K, V]) Trim(targetCapacity int) error {
+	return sm.trim(targetCapacity)
+}
+
+// Drops every tombstone like the automatic compaction does, but keeps the live entries
+// in their relative memory order: they're re-inserted in group order into a new table
+// of the same capacity. Entries that were inserted together end up close to each other,
+// which the in-place compaction doesn't guarantee.
+// Since every entry is placed from scratch, it also shortens probe chains left behind
+// by collisions with entries that have been deleted since.
+// The old and the new table are both held in memory until it completes.
+func (sm *StableMap[K, V]) CompactStable() {
+	sm.compactStable()
+}
+
+// Re-inserts every live entry into a new table of the same capacity, to fix a layout
+// degraded by collisions: long probe chains that the in-place compaction keeps, as it
+// only moves entries within them. Tombstones are dropped, and Stats().PeakProbeLength
+// is recomputed from the live entries. It does the same as CompactStable.
+// Under WithMaxProbe, the map is left untouched if the entries don't all fit the new layout.
+func (sm *StableMap[K, V]) Rebuild() {
+	sm.compactStable()
+}
+
+// Switches the map to the hash function `f`, e.g. after observing clustering with
+// the current one. Since every entry's position depends on its hash, the live entries
+// are re-inserted into a new table of the same capacity, and tombstones are dropped.
+// The old and the new table are both held in memory until it completes.
+// It's a no-op if `f` is nil. Under WithMaxProbe, returns ErrCapacityTooSmall and
+// leaves the map untouched if the entries don't all fit the new layout.
+func (sm *StableMap[K, V]) ReHashWith(f HashFunc[K]) error {
+	if f == nil {
+		return nil
+	}
+
+	return sm.rehash(f)
+}
+
+// Returns a point-in-time copy of the map, with the same capacity and options.
+// The groups storage is copied, so the snapshot can be read, e.g. exported in the
+// background, while the original keeps being modified, and vice versa.
+// Keys and values are copied shallowly: pointers, slices and maps in them are shared.
+// Taking a snapshot must itself be synchronized with writers of the original.
+func (sm *StableMap[K, V]) Snapshot() *StableMap[K, V] {
+	return &StableMap[K, V]{table: sm.clone()}
+}
+
+// Returns the number of entries for which `pred` returns true.
+// It walks the whole table.
+func (sm *StableMap[K, V]) Count(pred func(key K, value V) bool) int {
+	var n int
+	sm.all(func(key K, value V) bool {
+		if pred(key, value) {
+			n++
+		}
+		return true
+	})
+
+	return n
+}
+
+// Returns a new map holding the entries for which `pred` returns true.
+// The new map has the same options, and is sized to fit the matching entries.
+// `pred` is called twice per entry: once to size the new map and once to fill it.
+func (sm *StableMap[K, V]) Filter(pred func(key K, value V) bool) *StableMap[K, V] {
+	n := sm.Count(pred)
+
+	var filtered StableMap[K, V]
+	filtered.init(capacityForSize(n), sm.options()...)
+	// Growing an empty table can't fail, and neither can setting the entries afterwards,
+	// unless WithMaxProbe leaves some of them out.
+	_ = filtered.reserve(n)
+
+	sm.all(func(key K, value V) bool {
+		if pred(key, value) {
+			_ = filtered.set(key, value)
+		}
+		return true
+	})
+
+	return &filtered
+}
+
+// Returns the keys of the map sorted by `less`, for a deterministic enumeration
+// in tests and output. Keys are otherwise walked in hash order, which depends
+// on the hash seed.
+func (sm *StableMap[K, V]) SortedKeys(less func(a, b K) bool) []K {
+	keys := sm.collectKeys()
+	slices.SortFunc(keys, func(a, b K) int {
+		switch {
+		case less(a, b):
+			return -1
+		case less(b, a):
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	return keys
+}
+
+// Returns the keys of the map in ascending order.
+// It's StableMap.SortedKeys for ordered key types.
+func SortedKeysOrdered[K cmp.Ordered, V any](sm *StableMap[K, V]) []K {
+	keys := sm.collectKeys()
+	slices.Sort(keys)
+
+	return keys
+}
+
+// Returns the keys of the map in no particular order, stored into `dst` from its start.
+// `dst` is only reallocated if its capacity is too small, so a buffer can be reused
+// across calls without allocating, e.g. keys = sm.KeysInto(keys).
+func (sm *StableMap[K, V]) KeysInto(dst []K) []K {
+	dst = slices.Grow(dst[:0], int(sm.size))
+	sm.all(func(key K, _ V) bool {
+		dst = append(dst, key)
+		return true
+	})
+
+	return dst
+}
+
+// Returns the values of the map like KeysInto returns the keys,
+// in the same order as long as the map isn't modified in between.
+func (sm *StableMap[K, V]) ValuesInto(dst []V) []V {
+	dst = slices.Grow(dst[:0], int(sm.size))
+	sm.all(func(_ K, value V) bool {
+		dst = append(dst, value)
+		return true
+	})
+
+	return dst
+}
+
+// Returns an iterator over the entries of the map in no particular order,
+// to be used with a for-range loop or the iter-based helpers of the standard library,
+// e.g. maps.Collect. Breaking out of the loop stops the walk.
+// The map must not be modified during the iteration: compaction and resizing
+// move entries around, so they may be skipped or yielded twice.
+func (sm *StableMap[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		sm.all(yield)
+	}
+}
+
+// Returns the same iterator as All, named after maps.Collect which it's meant for:
+// maps.Collect(sm.Collect()) copies the map into a Go map.
+func (sm *StableMap[K, V]) Collect() iter.Seq2[K, V] {
+	return sm.All()
+}
+
+// Returns a Go map holding the entries of the map, sized for all of them.
+func (sm *StableMap[K, V]) ToMap() map[K]V {
+	m := make(map[K]V, sm.size)
+	sm.all(func(key K, value V) bool {
+		m[key] = value
+		return true
+	})
+
+	return m
+}
+
+// Entry is a key/value pair of a map.
+type Entry[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+// Returns the entries of the map in no particular order, e.g. for logging and tests.
+func (sm *StableMap[K, V]) Entries() []Entry[K, V] {
+	entries := make([]Entry[K, V], 0, sm.size)
+	sm.all(func(key K, value V) bool {
+		entries = append(entries, Entry[K, V]{Key: key, Value: value})
+		return true
+	})
+
+	return entries
+}
+
+func (sm *StableMap[K, V]) collectKeys() []K {
+	keys := make([]K, 0, sm.size)
+	sm.all(func(key K, _ V) bool {
+		keys = append(keys, key)
+		return true
+	})
+
+	return keys
+}
+
+// Returns the number of entries in the map.
+func (sm *StableMap[K, V]) Len() int {
+	return int(sm.size)
+}
+
+// Returns the number of bytes held by the map: the groups storage, the hash cache
+// if enabled with WithCachedHashes, plus the map header.
+// Memory referenced by keys or values (e.g. string or slice contents) isn't counted.
+func (sm *StableMap[K, V]) MemoryUsage() uintptr {
+	return uintptr(len(sm.groups))*unsafe.Sizeof(group[K, V]{}) +
+		uintptr(len(sm.hashes))*unsafe.Sizeof(uint64(0)) +
+		unsafe.Sizeof(*sm)
+}
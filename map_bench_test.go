@@ -1,7 +1,12 @@
 package stablemap
 
 import (
+	"math/bits"
+	"math/rand/v2"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"unsafe"
 )
@@ -201,7 +206,7 @@ func BenchmarkMemoryUsage_StableMap(b *testing.B) {
 
 	g := group[uint64, uint64]{}
 	for idx := range groupSize {
-		g.ctrls[idx] = 0x7b
+		g.ctrls[idx] = 0x7b | slotFull
 		g.slots[idx] = uint64(idx)
 	}
 
@@ -228,3 +233,444 @@ func BenchmarkMemoryUsage_StdMap(b *testing.B) {
 	runtime.ReadMemStats(&m2)
 	b.Logf("Actual Memory: %v MB\n", (m2.Alloc-m1.Alloc)/1024/1024)
 }
+
+func BenchmarkSyncMap_WriteHeavy(b *testing.B) {
+	const capacity = 1 << 20
+	m := NewSyncMap[uint64, uint64](capacity)
+	keys := setupBenchData(capacity / 2)
+
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			k := keys[i%len(keys)]
+			if i%4 == 3 {
+				m.Delete(k)
+				continue
+			}
+			_ = m.Set(k, k)
+		}
+	})
+}
+
+// Compare with BenchmarkSyncMap_WriteHeavy on several cores, with -cpu above 1.
+// With a single one there's no contention for the shards to remove, and picking
+// the shard only adds to every write.
+func BenchmarkShardedMap_WriteHeavy(b *testing.B) {
+	const capacity = 1 << 20
+	m := NewShardedMap(capacity, WithShards[uint64, uint64](64))
+	keys := setupBenchData(capacity / 2)
+
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			k := keys[i%len(keys)]
+			if i%4 == 3 {
+				m.Delete(k)
+				continue
+			}
+			_ = m.Set(k, k)
+		}
+	})
+}
+
+// benchmarkDistinctShards has every goroutine write to a shard of its own, so the
+// padded and unpadded layouts only differ by the cache lines adjacent shards share.
+// RunParallel starts GOMAXPROCS goroutines, one per shard: run it with -cpu above 1
+// on a machine with as many cores, with a single one both layouts measure the same.
+func benchmarkDistinctShards(b *testing.B, shards int, shardAt func(i int) *SyncMap[uint64, uint64]) {
+	for i := range shards {
+		shardAt(i).sm.init(64)
+	}
+
+	var next atomic.Int64
+
+	b.RunParallel(func(pb *testing.PB) {
+		m := shardAt(int(next.Add(1) - 1))
+
+		for i := uint64(0); pb.Next(); i++ {
+			k := i & 15
+			if i&1 == 1 {
+				m.Delete(k)
+				continue
+			}
+			_ = m.Set(k, k)
+		}
+	})
+}
+
+func BenchmarkShardedMap_DistinctShards_Padded(b *testing.B) {
+	shards := make([]shard[uint64, uint64], runtime.GOMAXPROCS(0))
+	benchmarkDistinctShards(b, len(shards), func(i int) *SyncMap[uint64, uint64] {
+		return &shards[i].SyncMap
+	})
+}
+
+func BenchmarkShardedMap_DistinctShards_Unpadded(b *testing.B) {
+	shards := make([]SyncMap[uint64, uint64], runtime.GOMAXPROCS(0))
+	benchmarkDistinctShards(b, len(shards), func(i int) *SyncMap[uint64, uint64] {
+		return &shards[i]
+	})
+}
+
+func setupGetManyBench(b *testing.B) (*StableMap[uint64, uint64], []uint64) {
+	const n = 1 << 20
+
+	sm := New[uint64, uint64](n * 2)
+	for i := range uint64(n) {
+		_ = sm.Set(i*0x9E3779B97F4A7C15, i)
+	}
+
+	// Random hits and misses
+	rng := rand.New(rand.NewPCG(1, 2))
+	keys := make([]uint64, n)
+	for i := range keys {
+		keys[i] = rng.Uint64N(n*2) * 0x9E3779B97F4A7C15
+	}
+
+	b.ResetTimer()
+
+	return sm, keys
+}
+
+func BenchmarkStableMap_GetMany(b *testing.B) {
+	sm, keys := setupGetManyBench(b)
+	values := make([]uint64, len(keys))
+	found := make([]bool, len(keys))
+
+	for b.Loop() {
+		sm.getMany(keys, values, found)
+	}
+}
+
+func BenchmarkStableMap_GetLoop(b *testing.B) {
+	sm, keys := setupGetManyBench(b)
+	values := make([]uint64, len(keys))
+	found := make([]bool, len(keys))
+
+	for b.Loop() {
+		for i, key := range keys {
+			values[i], found[i] = sm.Get(key)
+		}
+	}
+}
+
+// A batch of 6144 new keys into a map of 8192 slots, reset in between,
+// against the same keys set one by one.
+func BenchmarkStableMap_SetMany(b *testing.B) {
+	keys := setupBenchData(6144)
+	sm := New[uint64, uint64](8192)
+
+	for b.Loop() {
+		sm.Reset()
+		_, _ = sm.SetMany(keys, keys)
+	}
+}
+
+func BenchmarkStableMap_SetLoop(b *testing.B) {
+	keys := setupBenchData(6144)
+	sm := New[uint64, uint64](8192)
+
+	for b.Loop() {
+		sm.Reset()
+		for _, key := range keys {
+			_ = sm.Set(key, key)
+		}
+	}
+}
+
+// Misses at full load walk probe chains that often span several groups,
+// which makes this the benchmark most sensitive to memory latency per probe.
+func BenchmarkLargeScale_StableMap_HighLoadMiss(b *testing.B) {
+	const capacity = 4194304
+	sm := New[uint64, uint64](capacity)
+	fillCount := sm.Stats().EffectiveCapacity
+
+	for i := range fillCount {
+		_ = sm.Set(uint64(i*9876543210123), uint64(i))
+	}
+
+	for i := 0; b.Loop(); i++ {
+		sm.Get(uint64(i*9876543210123 + 1))
+	}
+}
+
+func BenchmarkStableMap_Get_HashUint64(b *testing.B) {
+	const capacity = 8192
+	keys := setupBenchData(capacity / 2)
+	sm := New(capacity, WithHashFunc[uint64, uint64](HashUint64))
+	for _, k := range keys {
+		_ = sm.Set(k, k)
+	}
+
+	for i := 0; b.Loop(); i++ {
+		sm.Get(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkStableMap_Get_DefaultHash(b *testing.B) {
+	const capacity = 8192
+	keys := setupBenchData(capacity / 2)
+	sm := New[uint64, uint64](capacity)
+	for _, k := range keys {
+		_ = sm.Set(k, k)
+	}
+
+	for i := 0; b.Loop(); i++ {
+		sm.Get(keys[i%len(keys)])
+	}
+}
+
+// clusteredHash sends runs of 32 consecutive keys to the same home group,
+// overflowing it into its neighbours, while keeping h2 well distributed.
+func clusteredHash(k uint64) uint64 {
+	return HashUint64(k)&0x7F | (k>>5)<<10
+}
+
+// homeClusteredHash sends keys to 1 in 256 home groups only, while keeping the rest
+// of the hash well distributed: the collisions are in the hash's low bits.
+func homeClusteredHash(k uint64) uint64 {
+	return HashUint64(k) &^ (0xFF << 10)
+}
+
+func benchmarkProbe(b *testing.B, hashFunc HashFunc[uint64], opts ...Option[uint64, uint64]) {
+	const capacity = 1 << 16
+	sm := New(capacity, append(opts, WithHashFunc[uint64, uint64](hashFunc))...)
+	fillCount := sm.Stats().EffectiveCapacity
+
+	for i := range fillCount {
+		_ = sm.Set(uint64(i), uint64(i))
+	}
+
+	// The mean number of groups visited, over 4096 keys spread over the range
+	// of `fillCount` keys from `offset`: the later a key is inserted, the longer its probe.
+	probes := func(offset int) float64 {
+		var total int
+		for i := range 4096 {
+			_, _, n := sm.GetWithProbes(uint64(offset + i*fillCount/4096))
+			total += n
+		}
+
+		return float64(total) / 4096
+	}
+
+	b.Run("Hit", func(b *testing.B) {
+		for i := 0; b.Loop(); i++ {
+			sm.Get(uint64(i % fillCount))
+		}
+		b.ReportMetric(probes(0), "probes/op")
+	})
+
+	b.Run("Miss", func(b *testing.B) {
+		for i := 0; b.Loop(); i++ {
+			sm.Get(uint64(fillCount + i))
+		}
+		b.ReportMetric(probes(fillCount), "probes/op")
+	})
+}
+
+func BenchmarkProbe_Quadratic_Uniform(b *testing.B) {
+	benchmarkProbe(b, HashUint64)
+}
+
+func BenchmarkProbe_Linear_Uniform(b *testing.B) {
+	benchmarkProbe(b, HashUint64, WithLinearProbe[uint64, uint64]())
+}
+
+func BenchmarkProbe_Quadratic_Clustered(b *testing.B) {
+	benchmarkProbe(b, clusteredHash)
+}
+
+func BenchmarkProbe_Linear_Clustered(b *testing.B) {
+	benchmarkProbe(b, clusteredHash, WithLinearProbe[uint64, uint64]())
+}
+
+func BenchmarkProbe_Quadratic_HomeClustered(b *testing.B) {
+	benchmarkProbe(b, homeClusteredHash)
+}
+
+func BenchmarkProbe_DoubleHashing_HomeClustered(b *testing.B) {
+	benchmarkProbe(b, homeClusteredHash, WithDoubleHashing[uint64, uint64]())
+}
+
+func BenchmarkProbe_DoubleHashing_Uniform(b *testing.B) {
+	benchmarkProbe(b, HashUint64, WithDoubleHashing[uint64, uint64]())
+}
+
+func benchmarkCompactStrings(b *testing.B, opts ...Option[string, int]) {
+	const capacity = 65536
+	tt := newTable(capacity, opts...)
+	for i := range tt.capacityEffective {
+		_ = tt.set(strings.Repeat("k", 32)+strconv.Itoa(int(i)), int(i))
+	}
+
+	// Compaction rehomes every live entry, with or without tombstones
+	for b.Loop() {
+		tt.compact()
+	}
+}
+
+func BenchmarkTable_Compact_Strings(b *testing.B) {
+	benchmarkCompactStrings(b)
+}
+
+func BenchmarkTable_Compact_Strings_CachedHashes(b *testing.B) {
+	benchmarkCompactStrings(b, WithCachedHashes[string, int]())
+}
+
+func BenchmarkTable_CompactParallel_Strings(b *testing.B) {
+	const capacity = 1 << 20
+	tt := newTable[string, int](capacity)
+	for i := range tt.capacityEffective {
+		_ = tt.set(strings.Repeat("k", 32)+strconv.Itoa(int(i)), int(i))
+	}
+
+	b.Run("serial", func(b *testing.B) {
+		for b.Loop() {
+			tt.compact()
+		}
+	})
+
+	for _, workers := range []int{2, 4, 8} {
+		b.Run("workers="+strconv.Itoa(workers), func(b *testing.B) {
+			for b.Loop() {
+				_ = tt.CompactParallel(workers)
+			}
+		})
+	}
+}
+
+// Keys share a 256-byte prefix, so comparing two of the same length walks the prefix.
+// Go's == on strings compares the lengths first and the bytes only if they match,
+// no specialization for string keys can skip more than that.
+func benchmarkLongStringKeys(b *testing.B, lookup func(i int) string) {
+	const capacity = 1 << 14
+	prefix := strings.Repeat("/very/long/common/path/prefix", 9)[:256]
+
+	sm := New[string, int](capacity)
+	n := sm.Stats().EffectiveCapacity
+	for i := range n {
+		_ = sm.Set(prefix+strconv.Itoa(i), i)
+	}
+
+	keys := make([]string, 4096)
+	for i := range keys {
+		// Copies, so that equal keys don't share their bytes with the stored ones
+		keys[i] = strings.Clone(prefix + lookup(i*n/len(keys)))
+	}
+
+	for i := 0; b.Loop(); i++ {
+		sm.Get(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkStableMap_Get_LongStringKeys_Hit(b *testing.B) {
+	benchmarkLongStringKeys(b, strconv.Itoa)
+}
+
+func BenchmarkStableMap_Get_LongStringKeys_Miss(b *testing.B) {
+	// Same lengths as the stored keys, only the last byte differs
+	benchmarkLongStringKeys(b, func(i int) string {
+		s := strconv.Itoa(i)
+		return s[:len(s)-1] + "x"
+	})
+}
+
+type bigValue [32]uint64
+
+var sinkBigValue bigValue
+
+func newBigValueMap() (*StableMap[uint64, bigValue], []uint64) {
+	const capacity = 8192
+	keys := setupBenchData(capacity / 2)
+	sm := New[uint64, bigValue](capacity)
+	for _, k := range keys {
+		_ = sm.Set(k, bigValue{k})
+	}
+
+	return sm, keys
+}
+
+func BenchmarkStableMap_Get_BigValue(b *testing.B) {
+	sm, keys := newBigValueMap()
+
+	for i := 0; b.Loop(); i++ {
+		sinkBigValue, _ = sm.Get(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkStableMap_Contains_BigValue(b *testing.B) {
+	sm, keys := newBigValueMap()
+
+	for i := 0; b.Loop(); i++ {
+		sm.Contains(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkStableMap_ShortLived_New(b *testing.B) {
+	b.ReportAllocs()
+
+	for b.Loop() {
+		sm := New[uint64, uint64](1024)
+		for i := range uint64(100) {
+			_ = sm.Set(i, i)
+		}
+	}
+}
+
+func BenchmarkStableMap_ShortLived_Pool(b *testing.B) {
+	b.ReportAllocs()
+	p := NewMapPool[uint64, uint64](1024)
+
+	for b.Loop() {
+		sm := p.Get()
+		for i := range uint64(100) {
+			_ = sm.Set(i, i)
+		}
+		p.Put(sm)
+	}
+}
+
+// Control bytes are interleaved with the slots, one 8-byte word per group, so Reset
+// touches one cache line per group whatever the fill strategy: it's bound by memory
+// bandwidth, not by the copies.
+func BenchmarkStableMap_Reset(b *testing.B) {
+	sm := New[uint64, uint64](1 << 22)
+
+	for b.Loop() {
+		sm.Reset()
+	}
+}
+
+// benchmarkUint32 fills maps of 2^22 and 2^26 slots, and looks up present and absent keys.
+// Keys are spread by an odd multiplier, which keeps them distinct.
+func benchmarkUint32(b *testing.B, opts ...Option[uint32, uint32]) {
+	const spread = 2654435761
+
+	for _, capacity := range []int{1 << 22, 1 << 26} {
+		b.Run("2^"+strconv.Itoa(bits.TrailingZeros(uint(capacity))), func(b *testing.B) {
+			sm := New(capacity, opts...)
+			fillCount := uint32(sm.Stats().EffectiveCapacity)
+			for i := range fillCount {
+				_ = sm.Set(i*spread, i)
+			}
+
+			b.Run("Hit", func(b *testing.B) {
+				for i := uint32(0); b.Loop(); i++ {
+					sm.Get((i % fillCount) * spread)
+				}
+			})
+
+			b.Run("Miss", func(b *testing.B) {
+				for i := uint32(0); b.Loop(); i++ {
+					sm.Get((fillCount + i%fillCount) * spread)
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkLargeScale_Uint32_DefaultHash(b *testing.B) {
+	benchmarkUint32(b)
+}
+
+func BenchmarkLargeScale_Uint32_HashUint32(b *testing.B) {
+	benchmarkUint32(b, WithHashFunc[uint32, uint32](HashUint32))
+}
@@ -1,7 +1,15 @@
 package stablemap
 
 import (
+	"fmt"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"unsafe"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -42,6 +50,730 @@ func TestStableMap_Basic(t *testing.T) {
 	assert.False(t, deleted)
 }
 
+func TestStableMap_Contains(t *testing.T) {
+	sm := New[string, [64]int](16)
+	require.NoError(t, sm.Set("foo", [64]int{1}))
+
+	assert.True(t, sm.Contains("foo"))
+	assert.False(t, sm.Contains("bar"))
+
+	require.True(t, sm.Delete("foo"))
+	assert.False(t, sm.Contains("foo"))
+}
+
+func TestStableMap_GetPtr(t *testing.T) {
+	type entry struct {
+		hits int
+		name string
+	}
+
+	sm := New[string, entry](16)
+	require.NoError(t, sm.Set("foo", entry{name: "foo"}))
+
+	ptr, ok := sm.GetPtr("foo")
+	require.True(t, ok)
+	ptr.hits++
+	ptr.hits++
+
+	v, ok := sm.Get("foo")
+	require.True(t, ok)
+	assert.Equal(t, entry{hits: 2, name: "foo"}, v)
+
+	ptr, ok = sm.GetPtr("bar")
+	assert.False(t, ok)
+	assert.Nil(t, ptr)
+}
+
+func TestStableMap_CompactStable(t *testing.T) {
+	// h2 is the key itself, all keys start at group 0
+	sm := New(64, WithHashFunc[int, int](func(k int) uint64 {
+		return uint64(k)
+	}), WithAutoCompact[int, int](1))
+
+	// 0..7 fill group 0, 8 and 9 spill over into the adjacent group 1
+	for k := range 10 {
+		require.NoError(t, sm.Set(k, k))
+	}
+	for k := range 4 {
+		require.True(t, sm.Delete(k))
+	}
+
+	order := func() []int {
+		var keys []int
+		sm.all(func(key, _ int) bool {
+			keys = append(keys, key)
+			return true
+		})
+		return keys
+	}
+	require.Equal(t, []int{4, 5, 6, 7, 8, 9}, order())
+	require.Equal(t, 4, sm.Stats().Tombstones)
+
+	sm.CompactStable()
+	assert.Zero(t, sm.Stats().Tombstones)
+	assert.Equal(t, 6, sm.Len())
+	require.NoError(t, sm.CheckInvariants())
+
+	// 8 and 9 moved into their home group, still in the same order
+	assert.Equal(t, []int{4, 5, 6, 7, 8, 9}, order())
+	assert.Equal(t, []int{4, 5, 6, 7, 8, 9}, sm.groups[0].slots[:6])
+}
+
+func TestStableMap_Rebuild(t *testing.T) {
+	// h1 is the key itself: key k's home group is (k/8)%32
+	sm := New(256, WithHashFunc[int, int](func(k int) uint64 {
+		return uint64(k)<<7 | uint64(k)&0x7F
+	}), WithAutoCompact[int, int](1))
+
+	// Fill groups 1..20
+	for k := 8; k < 168; k++ {
+		require.NoError(t, sm.Set(k, k))
+	}
+
+	// 24 keys homed at group 0 overflow into groups 0, 21 and 28,
+	// as groups 1, 3, 6, 10 and 15 on the way are full
+	var clustered []int
+	for j := range 3 {
+		for r := range 8 {
+			clustered = append(clustered, j*256+r)
+		}
+	}
+	for _, k := range clustered {
+		require.NoError(t, sm.Set(k, k))
+	}
+	require.Equal(t, 8, maxProbeLength(&sm.table))
+
+	for k := 8; k < 168; k++ {
+		require.True(t, sm.Delete(k))
+	}
+
+	// Tombstones don't shorten the chains
+	require.Equal(t, 8, maxProbeLength(&sm.table))
+	require.Equal(t, 8, sm.Stats().PeakProbeLength)
+
+	sm.Rebuild()
+	require.NoError(t, sm.CheckInvariants())
+
+	// Groups 0, 1 and 3 now hold the keys
+	assert.Equal(t, 3, maxProbeLength(&sm.table))
+	assert.Equal(t, 3, sm.Stats().PeakProbeLength)
+	assert.Equal(t, len(clustered), sm.Len())
+	for _, k := range clustered {
+		v, ok := sm.Get(k)
+		require.True(t, ok)
+		assert.Equal(t, k, v)
+	}
+}
+
+func TestTryNew(t *testing.T) {
+	sm, err := TryNew[int, int](1000)
+	require.NoError(t, err)
+	assert.Equal(t, 1024, sm.Stats().Capacity)
+
+	for _, capacity := range []int{-1, 0, math.MaxInt} {
+		sm, err := TryNew[int, int](capacity)
+		require.ErrorIsf(t, err, ErrInvalidCapacity, "capacity %d", capacity)
+		assert.Nil(t, sm)
+	}
+
+	// In range, but far too large to allocate with 1MB values
+	_, err = TryNew[int, [1 << 20]byte](1 << 30)
+	require.ErrorIs(t, err, ErrInvalidCapacity)
+}
+
+func TestTryNew_WithInitialData(t *testing.T) {
+	sm, err := TryNew(16, WithInitialData([]int{1, 2, 3}, []int{10, 20, 30}))
+	require.NoError(t, err)
+	assert.Equal(t, 3, sm.Len())
+
+	sm, err = TryNew(16, WithInitialData([]int{1, 2, 3}, []int{10, 20}))
+	require.ErrorIs(t, err, ErrLengthMismatch)
+	assert.Nil(t, sm)
+
+	// Only 7 of the 8 slots are usable
+	sm, err = TryNew(8, WithInitialData[int, int]([]int{1, 2, 3, 4, 5, 6, 7, 8}, nil))
+	require.ErrorIs(t, err, ErrTableFull)
+	assert.Nil(t, sm)
+}
+
+func TestTryNew_WithStrictValueSize(t *testing.T) {
+	sm, err := TryNew(64, WithStrictValueSize[int, [1024]byte]())
+	require.ErrorIs(t, err, ErrValueTooLarge)
+	assert.Nil(t, sm)
+
+	// Up to a cache line, or behind a pointer
+	_, err = TryNew(64, WithStrictValueSize[int, [64]byte]())
+	require.NoError(t, err)
+	ptrs, err := TryNew(64, WithStrictValueSize[int, *[1024]byte]())
+	require.NoError(t, err)
+	require.NoError(t, ptrs.Set(1, &[1024]byte{}))
+
+	// Large values are still allowed without the option
+	_, err = TryNew[int, [1024]byte](64)
+	require.NoError(t, err)
+
+	assert.PanicsWithError(t, fmt.Sprintf("%v: [1024]uint8 takes 1024 bytes, store a pointer to it instead", ErrValueTooLarge), func() {
+		New(64, WithStrictValueSize[int, [1024]byte]())
+	})
+}
+
+func TestStableMap_DeleteManyCompacting(t *testing.T) {
+	// Automatic compaction is off, only the batch compacts
+	compacting := New(1024, WithAutoCompact[int, int](1))
+	plain := New(1024, WithAutoCompact[int, int](1))
+	n := compacting.Stats().EffectiveCapacity
+	for i := range n {
+		require.NoError(t, compacting.Set(i, i))
+		require.NoError(t, plain.Set(i, i))
+	}
+
+	// Delete 90% of the entries, 64 at a time
+	toDelete := n * 9 / 10
+	for lo := 0; lo < toDelete; lo += 64 {
+		keys := make([]int, 0, 64)
+		for i := lo; i < min(lo+64, toDelete); i++ {
+			keys = append(keys, i)
+		}
+
+		assert.Equal(t, len(keys), compacting.DeleteManyCompacting(keys, 0.1))
+		assert.Equal(t, len(keys), plain.DeleteMany(keys))
+		assert.LessOrEqual(t, compacting.Stats().TombstonesCapacityRatio, float32(0.1))
+	}
+
+	require.NoError(t, compacting.CheckInvariants())
+	assert.Equal(t, n-toDelete, compacting.Len())
+	for i := toDelete; i < n; i++ {
+		v, ok := compacting.Get(i)
+		require.True(t, ok)
+		assert.Equal(t, i, v)
+	}
+
+	// Lookups of the deleted keys no longer walk past hundreds of tombstones
+	meanProbes := func(sm *StableMap[int, int]) float64 {
+		var total int
+		for i := range toDelete {
+			_, ok, probes := sm.GetWithProbes(i)
+			require.False(t, ok)
+			total += probes
+		}
+		return float64(total) / float64(toDelete)
+	}
+	assert.Less(t, meanProbes(compacting), meanProbes(plain)/2)
+
+	assert.Zero(t, compacting.DeleteManyCompacting([]int{-1, 0}, 0.1))
+}
+
+func TestStableMap_DeleteManyCompacting_MaxProbe(t *testing.T) {
+	// 8 groups, and every key fits in its home group or the next one.
+	// Keys 0..11 start at group 7, keys 12..23 at group 0.
+	var hashes int
+	home := func(k int) uint64 {
+		if k < 12 {
+			return 7
+		}
+		return 0
+	}
+	sm := New(64, WithHashFunc[int, int](func(k int) uint64 {
+		hashes++
+		return home(k)<<10 | uint64(k)
+	}), WithMaxProbe[int, int](2))
+
+	// Group 7 fills up, then group 0 takes 4 keys of group 7 and 4 of its own,
+	// and group 1 the overflow of group 0. Rebuilt in memory order, group 0 fills
+	// up with its own keys first, and the last keys of group 7 find no room.
+	for k := range 24 {
+		require.NoError(t, sm.Set(k, k))
+	}
+	require.ErrorIs(t, sm.resize(int(sm.capacity)), ErrCapacityTooSmall)
+	assert.Equal(t, 24, sm.Len())
+
+	// Deleting keys of group 1 leaves that layout as it is
+	hashes = 0
+	assert.Equal(t, 4, sm.DeleteManyCompacting([]int{16, 17, 18, 19}, 0.01))
+
+	// A single rebuild attempt hashes every entry, then the batch stops compacting
+	assert.Equal(t, 4, sm.Stats().Tombstones)
+	assert.LessOrEqual(t, hashes, 4+20)
+	require.NoError(t, sm.CheckInvariants())
+	for k := range 24 {
+		_, ok := sm.Get(k)
+		assert.Equal(t, k < 16 || k > 19, ok, "key %d", k)
+	}
+}
+
+func TestStableMap_GetOr(t *testing.T) {
+	sm := New[string, int](16)
+	require.NoError(t, sm.Set("a", 1))
+	require.NoError(t, sm.Set("zero", 0))
+
+	assert.Equal(t, 1, sm.GetOr("a", -1))
+	// A stored zero value is a hit
+	assert.Equal(t, 0, sm.GetOr("zero", -1))
+
+	before := sm.Stats()
+	assert.Equal(t, -1, sm.GetOr("missing", -1))
+	assert.Equal(t, before, sm.Stats())
+	assert.False(t, sm.Contains("missing"))
+}
+
+func TestStableMap_GetWithProbes(t *testing.T) {
+	// Every key has the same home group
+	sm := New(64, WithHashFunc[int, int](func(k int) uint64 {
+		return uint64(k & 0x7F)
+	}))
+	for i := range 24 {
+		require.NoError(t, sm.Set(i, i))
+	}
+
+	prev := 0
+	for i := range 24 {
+		v, ok, probes := sm.GetWithProbes(i)
+		require.True(t, ok)
+		assert.Equal(t, i, v)
+		// Keys inserted later sit further along the probe sequence
+		assert.Equal(t, i/groupSize+1, probes, "key %d", i)
+		assert.GreaterOrEqual(t, probes, prev)
+		prev = probes
+	}
+
+	// A miss walks to the first group with an empty slot
+	_, ok, probes := sm.GetWithProbes(100)
+	assert.False(t, ok)
+	assert.Equal(t, 4, probes)
+}
+
+func TestStableMap_FindKey(t *testing.T) {
+	strs := New[string, int](16)
+	stored := strings.Repeat("x", 10)
+	require.NoError(t, strs.Set(stored, 1))
+
+	// An equal string in another piece of memory
+	lookup := strings.Repeat("x", 10)
+	require.NotSame(t, unsafe.StringData(stored), unsafe.StringData(lookup))
+
+	key, ok := strs.FindKey(lookup)
+	require.True(t, ok)
+	assert.Same(t, unsafe.StringData(stored), unsafe.StringData(key))
+
+	_, ok = strs.FindKey("y")
+	assert.False(t, ok)
+
+	floats := New[float64, int](16)
+	require.NoError(t, floats.Set(math.Copysign(0, -1), 1))
+
+	key0, ok := floats.FindKey(0)
+	require.True(t, ok)
+	assert.True(t, math.Signbit(key0))
+}
+
+func TestStableMap_Purge(t *testing.T) {
+	secret := 42
+	sm := New(64, WithCachedHashes[string, *int](), WithAutoCompact[string, *int](1))
+	for i := range 40 {
+		require.NoError(t, sm.Set("token-"+strconv.Itoa(i), &secret))
+	}
+	for i := range 10 {
+		require.True(t, sm.Delete("token-"+strconv.Itoa(i)))
+	}
+
+	sm.Purge()
+	assert.Zero(t, sm.Len())
+	assert.Zero(t, sm.Stats().Tombstones)
+
+	// Keys and values of live and deleted entries are gone
+	for i := range sm.groups {
+		assert.Zero(t, sm.groups[i], "group %d", i)
+	}
+	for _, h := range sm.hashes {
+		assert.Zero(t, h)
+	}
+	require.NoError(t, sm.CheckInvariants())
+
+	// The map is still usable
+	require.NoError(t, sm.Set("token-1", &secret))
+	v, ok := sm.Get("token-1")
+	require.True(t, ok)
+	assert.Same(t, &secret, v)
+	assert.Equal(t, 1, sm.Len())
+}
+
+func TestStableMap_All(t *testing.T) {
+	sm := New[int, string](128)
+	for i := range 100 {
+		require.NoError(t, sm.Set(i, strconv.Itoa(i)))
+	}
+	for i := 0; i < 100; i += 3 {
+		require.True(t, sm.Delete(i))
+	}
+
+	want := make(map[int]string)
+	for i := range 100 {
+		if i%3 != 0 {
+			want[i] = strconv.Itoa(i)
+		}
+	}
+
+	assert.Equal(t, want, sm.ToMap())
+	assert.Equal(t, sm.ToMap(), maps.Collect(sm.Collect()))
+	assert.Equal(t, want, maps.Collect(sm.All()))
+
+	// Breaking out stops the walk
+	var n int
+	for k, v := range sm.All() {
+		assert.Equal(t, strconv.Itoa(k), v)
+		n++
+		if n == 5 {
+			break
+		}
+	}
+	assert.Equal(t, 5, n)
+
+	assert.Empty(t, New[int, int](16).ToMap())
+}
+
+func TestStableMap_Entries(t *testing.T) {
+	sm := New[int, string](64)
+	assert.Empty(t, sm.Entries())
+
+	for i := range 20 {
+		require.NoError(t, sm.Set(i, strconv.Itoa(i)))
+	}
+	for i := range 5 {
+		require.True(t, sm.Delete(i))
+	}
+	require.NoError(t, sm.Set(10, "ten"))
+
+	var want []Entry[int, string]
+	for i := 5; i < 20; i++ {
+		want = append(want, Entry[int, string]{Key: i, Value: strconv.Itoa(i)})
+	}
+	want[5].Value = "ten"
+
+	entries := sm.Entries()
+	assert.Len(t, entries, sm.Len())
+	assert.ElementsMatch(t, want, entries)
+}
+
+func TestStableMap_KeysInto_ValuesInto(t *testing.T) {
+	sm := New[int, int](64)
+	for i := range 10 {
+		require.NoError(t, sm.Set(i, i*10))
+	}
+
+	buf := make([]int, 3, 16)
+	keys := sm.KeysInto(buf)
+	assert.ElementsMatch(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, keys)
+	require.Same(t, &buf[:1][0], &keys[0])
+
+	require.True(t, sm.Delete(0))
+	keys = sm.KeysInto(keys)
+	assert.Len(t, keys, 9)
+	assert.Same(t, &buf[:1][0], &keys[0])
+
+	values := sm.ValuesInto(nil)
+	for i, key := range keys {
+		assert.Equal(t, key*10, values[i])
+	}
+
+	// Too small to fit, reallocated
+	small := make([]int, 0, 4)
+	keys = sm.KeysInto(small)
+	assert.Len(t, keys, 9)
+	assert.NotSame(t, &small[:1][0], &keys[0])
+
+	allocs := testing.AllocsPerRun(10, func() {
+		keys = sm.KeysInto(keys)
+	})
+	assert.Zero(t, allocs)
+}
+
+func TestStableMap_ReHashWith(t *testing.T) {
+	// Every key starts at group 0, only h2 tells them apart
+	sm := New(1024, WithHashFunc[uint64, uint64](func(k uint64) uint64 {
+		return k & 0x7F
+	}))
+
+	for k := range uint64(500) {
+		require.NoError(t, sm.Set(k, k*3))
+	}
+	require.True(t, sm.Delete(0))
+
+	before := maxProbeLength(&sm.table)
+	require.Greater(t, before, 50)
+
+	require.NoError(t, sm.ReHashWith(HashUint64))
+	require.NoError(t, sm.CheckInvariants())
+
+	assert.Less(t, maxProbeLength(&sm.table), before/10)
+	assert.Zero(t, sm.Stats().Tombstones)
+	assert.Equal(t, 499, sm.Len())
+	for k := uint64(1); k < 500; k++ {
+		v, ok := sm.Get(k)
+		require.True(t, ok)
+		assert.Equal(t, k*3, v)
+	}
+
+	// New entries go through the new hash function as well
+	require.NoError(t, sm.Set(1000, 1))
+	assert.Equal(t, 1, probeLength(&sm.table, uint64(1000)))
+}
+
+func TestStableMap_Snapshot(t *testing.T) {
+	sm := New[int, string](64, WithCachedHashes[int, string]())
+	for i := range 40 {
+		require.NoError(t, sm.Set(i, strconv.Itoa(i)))
+	}
+
+	snapshot := sm.Snapshot()
+	assert.Equal(t, sm.Stats(), snapshot.Stats())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 40 {
+			_ = sm.Set(i, "changed")
+		}
+		for i := 20; i < 40; i++ {
+			_ = sm.Delete(i)
+		}
+	}()
+
+	for i := range 40 {
+		v, ok := snapshot.Get(i)
+		require.True(t, ok)
+		assert.Equal(t, strconv.Itoa(i), v)
+	}
+	<-done
+
+	assert.Equal(t, 40, snapshot.Len())
+	require.NoError(t, snapshot.CheckInvariants())
+	assert.Equal(t, 20, sm.Len())
+	v, _ := sm.Get(0)
+	assert.Equal(t, "changed", v)
+
+	// The snapshot is a regular map
+	require.NoError(t, snapshot.Set(100, "100"))
+	assert.False(t, sm.Contains(100))
+}
+
+func TestStableMap_Count(t *testing.T) {
+	sm := New[string, int](64)
+	for i := range 40 {
+		require.NoError(t, sm.Set(strconv.Itoa(i), i))
+	}
+	require.True(t, sm.Delete("39"))
+
+	assert.Equal(t, 8, sm.Count(func(_ string, v int) bool { return v > 30 }))
+	assert.Equal(t, 39, sm.Count(func(string, int) bool { return true }))
+}
+
+func TestStableMap_Filter(t *testing.T) {
+	sm := New[int, string](1024, WithLoadFactor[int, string](0.5))
+	for i := range 500 {
+		require.NoError(t, sm.Set(i, strconv.Itoa(i)))
+	}
+	for i := range 100 {
+		require.True(t, sm.Delete(i))
+	}
+
+	filtered := sm.Filter(func(key int, _ string) bool { return key%2 == 0 })
+	assert.Equal(t, 200, filtered.Len())
+	assert.Equal(t, 400, sm.Len())
+	// Sized for the matching entries, under the source's load factor
+	assert.Equal(t, 512, filtered.Stats().Capacity)
+	assert.Equal(t, 256, filtered.Stats().EffectiveCapacity)
+
+	for i := range 500 {
+		v, ok := filtered.Get(i)
+		require.Equal(t, i >= 100 && i%2 == 0, ok, "key %d", i)
+		if ok {
+			assert.Equal(t, strconv.Itoa(i), v)
+		}
+	}
+
+	assert.Zero(t, sm.Filter(func(int, string) bool { return false }).Len())
+}
+
+func TestMapValues(t *testing.T) {
+	src := New[int, int](64, WithHashFunc[int, int](func(k int) uint64 { return HashUint64(uint64(k)) }))
+	for i := range 50 {
+		require.NoError(t, src.Set(i, i*i))
+	}
+	require.True(t, src.Delete(7))
+
+	dst, err := MapValues(src, func(key, value int) string {
+		return strconv.Itoa(key) + ":" + strconv.Itoa(value)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 49, dst.Len())
+	assert.Equal(t, src.Stats().Capacity, dst.Stats().Capacity)
+	assert.Equal(t, src.hashFunc(3), dst.hashFunc(3))
+
+	for i := range 50 {
+		v, ok := dst.Get(i)
+		require.Equal(t, i != 7, ok)
+		if ok {
+			assert.Equal(t, strconv.Itoa(i)+":"+strconv.Itoa(i*i), v)
+		}
+	}
+}
+
+func TestStableMap_SortedKeys(t *testing.T) {
+	want := make([]string, 0, 100)
+	for i := range 100 {
+		want = append(want, fmt.Sprintf("key-%03d", i))
+	}
+
+	// Every map gets a different random seed, hence a different layout
+	for range 5 {
+		sm := New[string, int](256)
+		for _, i := range rand.Perm(100) {
+			require.NoError(t, sm.Set(want[i], i))
+		}
+
+		assert.Equal(t, want, sm.SortedKeys(func(a, b string) bool { return a < b }))
+		assert.Equal(t, want, SortedKeysOrdered(sm))
+	}
+
+	sm := New[int, int](16)
+	for i := range 10 {
+		require.NoError(t, sm.Set(i, i))
+	}
+	assert.Equal(t, []int{9, 8, 7, 6, 5, 4, 3, 2, 1, 0}, sm.SortedKeys(func(a, b int) bool { return a > b }))
+	assert.Empty(t, SortedKeysOrdered(New[int, int](16)))
+}
+
+func TestStableMap_Swap(t *testing.T) {
+	sm := New[string, int](8)
+
+	previous, loaded, err := sm.Swap("foo", 1)
+	require.NoError(t, err)
+	assert.False(t, loaded)
+	assert.Zero(t, previous)
+
+	previous, loaded, err = sm.Swap("foo", 2)
+	require.NoError(t, err)
+	assert.True(t, loaded)
+	assert.Equal(t, 1, previous)
+
+	v, ok := sm.Get("foo")
+	require.True(t, ok)
+	assert.Equal(t, 2, v)
+
+	for i := sm.Len(); i < sm.Stats().EffectiveCapacity; i++ {
+		require.NoError(t, sm.Set(strconv.Itoa(i), i))
+	}
+
+	_, loaded, err = sm.Swap("bar", 3)
+	require.ErrorIs(t, err, ErrTableFull)
+	assert.False(t, loaded)
+
+	// Present keys are still swapped in a full table
+	previous, loaded, err = sm.Swap("foo", 3)
+	require.NoError(t, err)
+	assert.True(t, loaded)
+	assert.Equal(t, 2, previous)
+}
+
+func TestStableMap_CompareAndSwap(t *testing.T) {
+	sm := New[string, []int](16)
+	require.NoError(t, sm.Set("foo", []int{1}))
+
+	eq := slices.Equal[[]int]
+
+	assert.True(t, sm.CompareAndSwap("foo", []int{1}, []int{2}, eq))
+	v, _ := sm.Get("foo")
+	assert.Equal(t, []int{2}, v)
+
+	// Mismatch
+	assert.False(t, sm.CompareAndSwap("foo", []int{1}, []int{3}, eq))
+	v, _ = sm.Get("foo")
+	assert.Equal(t, []int{2}, v)
+
+	// Missing key, even if the zero value matches
+	assert.False(t, sm.CompareAndSwap("bar", nil, []int{3}, eq))
+	assert.False(t, sm.Contains("bar"))
+}
+
+func TestStableMap_WithCompactionPolicy_Writes(t *testing.T) {
+	var calls int
+	sm := New(16, WithCompactionPolicy[int, int](1, func(Stats) bool {
+		calls++
+		return true
+	}))
+
+	for i := range 3 {
+		require.NoError(t, sm.Set(i, i))
+	}
+	assert.Equal(t, 3, calls)
+
+	// Overwrites count like any other write
+	_, loaded, err := sm.Swap(0, 10)
+	require.NoError(t, err)
+	require.True(t, loaded)
+	assert.Equal(t, 4, calls)
+
+	eq := func(a, b int) bool { return a == b }
+	require.True(t, sm.CompareAndSwap(1, 1, 11, eq))
+	assert.Equal(t, 5, calls)
+	require.False(t, sm.CompareAndSwap(1, 1, 12, eq))
+	assert.Equal(t, 5, calls)
+
+	// Moving the entries to a new layout doesn't
+	require.NoError(t, sm.Grow(1024))
+	require.NoError(t, sm.Trim(16))
+	assert.Equal(t, 5, calls)
+
+	assert.Equal(t, map[int]int{0: 10, 1: 11, 2: 2}, sm.ToMap())
+	require.NoError(t, sm.CheckInvariants())
+}
+
+func TestStableMap_CompareAndDelete(t *testing.T) {
+	sm := New[string, int](16)
+	require.NoError(t, sm.Set("foo", 1))
+
+	eq := func(a, b int) bool { return a == b }
+
+	// Mismatch
+	assert.False(t, sm.CompareAndDelete("foo", 2, eq))
+	assert.True(t, sm.Contains("foo"))
+
+	assert.True(t, sm.CompareAndDelete("foo", 1, eq))
+	assert.False(t, sm.Contains("foo"))
+	assert.Zero(t, sm.Len())
+	assert.Equal(t, 1, sm.Stats().Tombstones)
+
+	// Missing key, even if the zero value matches
+	assert.False(t, sm.CompareAndDelete("bar", 0, eq))
+	assert.Equal(t, 1, sm.Stats().Tombstones)
+}
+
+func TestStableMap_SetHashed_GetHashed(t *testing.T) {
+	hashFunc := func(k string) uint64 { return HashUint64(uint64(len(k))) }
+
+	sm := New(64, WithHashFunc[string, int](hashFunc))
+	ref := New(64, WithHashFunc[string, int](hashFunc))
+
+	for i := range 50 {
+		key := strconv.Itoa(i)
+		require.NoError(t, sm.SetHashed(key, hashFunc(key), i))
+		require.NoError(t, ref.Set(key, i))
+	}
+	require.NoError(t, sm.SetHashed("7", hashFunc("7"), 70))
+	require.NoError(t, ref.Set("7", 70))
+
+	assert.Equal(t, ref.groups, sm.groups)
+	assert.Equal(t, ref.Stats(), sm.Stats())
+
+	for i := range 60 {
+		key := strconv.Itoa(i)
+		v, ok := sm.GetHashed(key, hashFunc(key))
+		wantV, wantOK := ref.Get(key)
+		assert.Equal(t, wantOK, ok)
+		assert.Equal(t, wantV, v)
+	}
+}
+
 func TestStableMap_Stats(t *testing.T) {
 	sm := New[int, int](16)
 
@@ -57,6 +789,81 @@ func TestStableMap_Stats(t *testing.T) {
 	assert.Equal(t, 5, stats.Size)
 }
 
+func TestStableMap_New_Capacity(t *testing.T) {
+	stats := New[int, int](1000).Stats()
+	assert.Equal(t, 1024, stats.Capacity)
+	assert.Equal(t, 896, stats.EffectiveCapacity)
+
+	stats = New[int, int](1024).Stats()
+	assert.Equal(t, 1024, stats.Capacity)
+}
+
+func TestStableMap_New_Tiny(t *testing.T) {
+	for _, capacity := range []int{0, 1, 7} {
+		sm := New[int, int](capacity)
+		assert.Equal(t, groupSize, sm.Stats().Capacity)
+
+		for i := range sm.Stats().EffectiveCapacity {
+			require.NoError(t, sm.Set(i, i))
+		}
+		require.ErrorIs(t, sm.Set(-1, -1), ErrTableFull)
+
+		v, ok := sm.Get(3)
+		require.True(t, ok)
+		assert.Equal(t, 3, v)
+	}
+
+	ss := NewSet[int](0)
+	require.NoError(t, ss.Put(1))
+	assert.True(t, ss.Has(1))
+}
+
+func TestStableMap_New_Negative(t *testing.T) {
+	sm := New[int, int](-5)
+	assert.Equal(t, groupSize, sm.Stats().Capacity)
+	assert.Len(t, sm.groups, 1)
+
+	require.NoError(t, sm.Set(1, 1))
+	v, ok := sm.Get(1)
+	require.True(t, ok)
+	assert.Equal(t, 1, v)
+
+	assert.Equal(t, groupSize, NewSet[int](-1<<40).Stats().Capacity)
+}
+
+func TestStableMap_WithInitialData(t *testing.T) {
+	keys := []string{"a", "b", "c", "a"}
+	values := []int{1, 2, 3, 4}
+
+	sm := New(16, WithInitialData(keys, values))
+	assert.Equal(t, 3, sm.Len())
+	for key, want := range map[string]int{"a": 4, "b": 2, "c": 3} {
+		v, ok := sm.Get(key)
+		require.True(t, ok)
+		assert.Equal(t, want, v)
+	}
+
+	// The data is loaded with the hash function set by the options, whatever their order
+	var calls int
+	sm = New(16, WithInitialData(keys, values), WithHashFunc[string, int](func(k string) uint64 {
+		calls++
+		return HashUint64(uint64(k[0]))
+	}))
+	assert.Equal(t, 4, calls)
+	require.NoError(t, sm.CheckInvariants())
+
+	ss := NewSet(16, WithInitialData[int, struct{}]([]int{1, 2, 3}, nil))
+	assert.ElementsMatch(t, []int{1, 2, 3}, ss.ToSlice())
+
+	assert.PanicsWithValue(t, ErrLengthMismatch, func() {
+		New(16, WithInitialData(keys, values[:2]))
+	})
+	assert.Panics(t, func() {
+		// Only 7 of the 8 slots are usable
+		New(8, WithInitialData[int, int]([]int{1, 2, 3, 4, 5, 6, 7, 8}, nil))
+	})
+}
+
 func TestStableMap_AutoCompaction(t *testing.T) {
 	sm := New[int, int](32)
 	effectiveCapacity := sm.Stats().EffectiveCapacity
@@ -114,6 +921,32 @@ func TestStableMap_ErrTableFull(t *testing.T) {
 	assert.ErrorIs(t, err, ErrTableFull)
 }
 
+func TestStableMap_ErrTableFull_Tombstones(t *testing.T) {
+	// Never compact on delete, so that every tombstone stays in place
+	sm := New[int, int](64, WithAutoCompact[int, int](1))
+	capacity := sm.Stats().EffectiveCapacity
+
+	for i := range capacity {
+		require.NoError(t, sm.Set(i, i))
+	}
+	for i := range capacity / 2 {
+		require.True(t, sm.Delete(i))
+	}
+	require.Equal(t, capacity/2, sm.Stats().Tombstones)
+
+	// Tombstones are reused by inserts, they never make Set fail
+	for i := capacity; sm.Len() < capacity; i++ {
+		require.NoError(t, sm.Set(i, i))
+	}
+	assert.ErrorIs(t, sm.Set(-1, -1), ErrTableFull)
+
+	// ErrTableFull only means the map holds EffectiveCapacity entries,
+	// reclaiming the remaining tombstones doesn't make room for more
+	sm.compact()
+	assert.Zero(t, sm.Stats().Tombstones)
+	assert.ErrorIs(t, sm.Set(-1, -1), ErrTableFull)
+}
+
 func TestStableMap_WithHashFunc(t *testing.T) {
 	customHash := func(k int) uint64 {
 		return uint64(k * 31)
@@ -126,3 +959,306 @@ func TestStableMap_WithHashFunc(t *testing.T) {
 	require.True(t, ok)
 	assert.Equal(t, 100, v)
 }
+
+func TestStableMap_Grow(t *testing.T) {
+	sm := New[int, int](16)
+
+	var n int
+	for ; ; n++ {
+		if err := sm.Set(n, n*10); err != nil {
+			require.ErrorIs(t, err, ErrTableFull)
+			break
+		}
+	}
+
+	// Leave a tombstone behind, it must be dropped by the migration
+	require.True(t, sm.Delete(0))
+	require.NoError(t, sm.Set(0, 0))
+	require.True(t, sm.Delete(n-1))
+	require.Equal(t, 1, sm.Stats().Tombstones)
+
+	require.NoError(t, sm.Grow(32))
+	assert.Equal(t, 0, sm.Stats().Tombstones)
+	assert.Equal(t, 28, sm.Stats().EffectiveCapacity)
+	assert.Equal(t, n-1, sm.Len())
+
+	for i := range n - 1 {
+		v, ok := sm.Get(i)
+		require.True(t, ok)
+		assert.Equal(t, i*10, v)
+	}
+
+	for i := n - 1; sm.Len() < sm.Stats().EffectiveCapacity; i++ {
+		require.NoError(t, sm.Set(i, i*10))
+	}
+}
+
+func TestStableMap_Reserve(t *testing.T) {
+	sm := New[int, int](64)
+	for i := range 50 {
+		require.NoError(t, sm.Set(i, i))
+	}
+
+	// Fits already
+	require.NoError(t, sm.Reserve(6))
+	assert.Equal(t, 64, sm.Stats().Capacity)
+
+	require.NoError(t, sm.Reserve(1000))
+	assert.GreaterOrEqual(t, sm.Stats().EffectiveCapacity, 1050)
+
+	for i := 50; i < 1050; i++ {
+		require.NoError(t, sm.Set(i, i))
+	}
+
+	for i := range 1050 {
+		v, ok := sm.Get(i)
+		require.True(t, ok)
+		assert.Equal(t, i, v)
+	}
+}
+
+func TestStableMap_Reserve_TooLarge(t *testing.T) {
+	sm := New[int, int](64)
+	for i := range 20 {
+		require.NoError(t, sm.Set(i, i))
+	}
+
+	// Rejected up front rather than looping over capacities or allocating 2^31 slots
+	require.ErrorIs(t, sm.Reserve(math.MaxInt), ErrInvalidCapacity)
+	require.ErrorIs(t, sm.Grow(math.MaxInt), ErrInvalidCapacity)
+
+	// The map is left untouched
+	assert.Equal(t, 64, sm.Stats().Capacity)
+	assert.Equal(t, 20, sm.Len())
+	require.NoError(t, sm.CheckInvariants())
+}
+
+func TestStableMap_Grow_TooSmall(t *testing.T) {
+	sm := New[int, int](64)
+	for i := range 20 {
+		require.NoError(t, sm.Set(i, i))
+	}
+
+	require.ErrorIs(t, sm.Grow(10), ErrCapacityTooSmall)
+
+	// Not larger than the current capacity, nothing to do
+	require.NoError(t, sm.Grow(40))
+	assert.Equal(t, 56, sm.Stats().EffectiveCapacity)
+	assert.Equal(t, 20, sm.Len())
+}
+
+func TestStableMap_Trim(t *testing.T) {
+	sm := New[int, int](1024)
+	for i := range 896 {
+		require.NoError(t, sm.Set(i, i))
+	}
+
+	for i := range 890 {
+		require.True(t, sm.Delete(i))
+	}
+
+	require.NoError(t, sm.Trim(0))
+
+	stats := sm.Stats()
+	assert.Equal(t, 8, stats.Capacity)
+	assert.Equal(t, 6, stats.Size)
+	assert.Equal(t, 0, stats.Tombstones)
+
+	for i := 890; i < 896; i++ {
+		v, ok := sm.Get(i)
+		require.True(t, ok)
+		assert.Equal(t, i, v)
+	}
+}
+
+func TestStableMap_Trim_KeepsRoomForSize(t *testing.T) {
+	sm := New[int, int](1024)
+	for i := range 100 {
+		require.NoError(t, sm.Set(i, i))
+	}
+
+	// 100 entries need 115 slots under the load factor, so 128 it is
+	require.NoError(t, sm.Trim(16))
+	assert.Equal(t, 128, sm.Stats().Capacity)
+	assert.Equal(t, 100, sm.Len())
+
+	// Not smaller than the current capacity, nothing to do
+	require.NoError(t, sm.Trim(512))
+	assert.Equal(t, 128, sm.Stats().Capacity)
+}
+
+func TestStableMap_DeleteIf(t *testing.T) {
+	sm := New[int, int](128)
+	for i := range 80 {
+		require.NoError(t, sm.Set(i, i*3))
+	}
+
+	deleted := sm.DeleteIf(func(_ int, v int) bool {
+		return v%2 == 0
+	})
+
+	assert.Equal(t, 40, deleted)
+	assert.Equal(t, 40, sm.Len())
+
+	for i := range 80 {
+		v, ok := sm.Get(i)
+		if (i*3)%2 == 0 {
+			assert.Falsef(t, ok, "%d should be deleted", i)
+			continue
+		}
+
+		require.Truef(t, ok, "%d should survive", i)
+		assert.Equal(t, i*3, v)
+	}
+
+	// 40 tombstones exceed the default threshold (112/3), so the sweep compacted
+	assert.Equal(t, 0, sm.Stats().Tombstones)
+
+	assert.Equal(t, 0, sm.DeleteIf(func(int, int) bool { return false }))
+}
+
+func TestStableMap_DeleteIf_Tombstones(t *testing.T) {
+	sm := New[int, int](128)
+	for i := range 60 {
+		require.NoError(t, sm.Set(i, i))
+	}
+
+	assert.Equal(t, 5, sm.DeleteIf(func(k int, _ int) bool { return k < 5 }))
+	assert.Equal(t, 5, sm.Stats().Tombstones)
+	assert.Equal(t, 55, sm.Len())
+}
+
+func TestStableMap_SetMany(t *testing.T) {
+	sm := New[int, string](16)
+
+	n, err := sm.SetMany([]int{1, 2, 3}, []string{"a", "b", "c"})
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, 3, sm.Len())
+
+	v, ok := sm.Get(2)
+	require.True(t, ok)
+	assert.Equal(t, "b", v)
+
+	n, err = sm.SetMany([]int{4, 5}, []string{"d"})
+	require.ErrorIs(t, err, ErrLengthMismatch)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, 3, sm.Len())
+}
+
+func TestStableMap_SetMany_Overflow(t *testing.T) {
+	sm := New[int, int](16)
+	capacity := sm.Stats().EffectiveCapacity
+
+	keys := make([]int, capacity+5)
+	values := make([]int, capacity+5)
+	for i := range keys {
+		keys[i], values[i] = i, i*10
+	}
+
+	n, err := sm.SetMany(keys, values)
+	require.ErrorIs(t, err, ErrTableFull)
+	assert.Equal(t, capacity, n)
+	assert.Equal(t, capacity, sm.Len())
+
+	for i := range n {
+		v, ok := sm.Get(i)
+		require.True(t, ok)
+		assert.Equal(t, i*10, v)
+	}
+
+	_, ok := sm.Get(capacity)
+	assert.False(t, ok)
+}
+
+func TestStableMap_SetFromMap(t *testing.T) {
+	sm := New[int, string](16)
+	require.NoError(t, sm.Set(1, "a"))
+
+	n, err := sm.SetFromMap(map[int]string{1: "b", 2: "c", 3: "d"})
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, 3, sm.Len())
+
+	v, ok := sm.Get(1)
+	require.True(t, ok)
+	assert.Equal(t, "b", v)
+
+	n, err = sm.SetFromMap(nil)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestStableMap_SetFromMap_Overflow(t *testing.T) {
+	sm := New[int, int](16)
+	capacity := sm.Stats().EffectiveCapacity
+
+	delta := make(map[int]int)
+	for i := range capacity + 5 {
+		delta[i] = i * 10
+	}
+
+	n, err := sm.SetFromMap(delta)
+	require.ErrorIs(t, err, ErrTableFull)
+	assert.Equal(t, capacity, n)
+	assert.Equal(t, capacity, sm.Len())
+
+	// Whichever keys were set have their values
+	sm.all(func(key, value int) bool {
+		assert.Equal(t, delta[key], value)
+		return true
+	})
+}
+
+func TestStableMap_DeleteMany(t *testing.T) {
+	sm := New[int, int](16)
+	for i := range 10 {
+		require.NoError(t, sm.Set(i, i))
+	}
+
+	assert.Equal(t, 3, sm.DeleteMany([]int{1, 3, 5, 100, 200}))
+	assert.Equal(t, 7, sm.Len())
+
+	_, ok := sm.Get(3)
+	assert.False(t, ok)
+}
+
+func TestStableMap_GetMany(t *testing.T) {
+	sm := New[int, int](1024)
+	for i := range 500 {
+		require.NoError(t, sm.Set(i*2, i))
+	}
+
+	keys := make([]int, 0, 1000)
+	for i := range 1000 {
+		keys = append(keys, i)
+	}
+
+	values, found := sm.GetMany(keys)
+	require.Len(t, values, len(keys))
+	require.Len(t, found, len(keys))
+
+	for i, key := range keys {
+		v, ok := sm.Get(key)
+		assert.Equal(t, ok, found[i])
+		assert.Equal(t, v, values[i])
+	}
+
+	values, found = sm.GetMany(nil)
+	assert.Empty(t, values)
+	assert.Empty(t, found)
+}
+
+func TestStableMap_MemoryUsage(t *testing.T) {
+	sm := New[int, int](4096)
+
+	// 512 groups of 8 control bytes, 8 keys and 8 values
+	groupsBytes := uintptr(512 * groupSize * (1 + 8 + 8))
+	assert.Equal(t, groupsBytes+unsafe.Sizeof(*sm), sm.MemoryUsage())
+
+	// Independent of the number of entries
+	for i := range 1000 {
+		require.NoError(t, sm.Set(i, i))
+	}
+	assert.Equal(t, groupsBytes+unsafe.Sizeof(*sm), sm.MemoryUsage())
+}
@@ -0,0 +1,40 @@
+package stablemap
+
+import "expvar"
+
+// PublishMetrics registers expvar variables reporting the map's Stats, read
+// on every scrape: <prefix>.size, <prefix>.tombstones, <prefix>.capacity and
+// <prefix>.tombstone_ratio (tombstones to effective capacity).
+// Like expvar.Publish, it panics if any of these names is already registered.
+//
+// Scrapes run on other goroutines, e.g. the expvar HTTP handler, while StableMap
+// isn't safe for concurrent use. Only publish a map that's no longer modified,
+// or use SyncMap.PublishMetrics instead.
+func (sm *StableMap[K, V]) PublishMetrics(prefix string) {
+	publishStats(prefix, sm.Stats)
+}
+
+// PublishMetrics is StableMap.PublishMetrics, reading the stats under the read lock.
+func (m *SyncMap[K, V]) PublishMetrics(prefix string) {
+	publishStats(prefix, func() Stats {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+
+		return m.sm.Stats()
+	})
+}
+
+func publishStats(prefix string, stats func() Stats) {
+	expvar.Publish(prefix+".size", expvar.Func(func() any {
+		return stats().Size
+	}))
+	expvar.Publish(prefix+".tombstones", expvar.Func(func() any {
+		return stats().Tombstones
+	}))
+	expvar.Publish(prefix+".capacity", expvar.Func(func() any {
+		return stats().Capacity
+	}))
+	expvar.Publish(prefix+".tombstone_ratio", expvar.Func(func() any {
+		return stats().TombstonesCapacityRatio
+	}))
+}
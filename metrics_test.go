@@ -0,0 +1,47 @@
+package stablemap
+
+import (
+	"expvar"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStableMap_PublishMetrics(t *testing.T) {
+	sm := New[int, int](64)
+	// Unique per map, expvar names can't be registered twice
+	prefix := fmt.Sprintf("stablemap_%p", sm)
+	sm.PublishMetrics(prefix)
+
+	value := func(name string) string {
+		v := expvar.Get(prefix + "." + name)
+		require.NotNil(t, v, name)
+		return v.String()
+	}
+
+	assert.Equal(t, "0", value("size"))
+	assert.Equal(t, "64", value("capacity"))
+
+	for i := range 10 {
+		require.NoError(t, sm.Set(i, i))
+	}
+	require.True(t, sm.Delete(0))
+
+	// Values are read on every scrape
+	assert.Equal(t, "9", value("size"))
+	assert.Equal(t, "1", value("tombstones"))
+	assert.Equal(t, "0.017857144", value("tombstone_ratio"))
+
+	assert.Panics(t, func() { sm.PublishMetrics(prefix) })
+}
+
+func TestSyncMap_PublishMetrics(t *testing.T) {
+	m := NewSyncMap[int, int](64)
+	prefix := fmt.Sprintf("syncmap_%p", m)
+	m.PublishMetrics(prefix)
+
+	require.NoError(t, m.Set(1, 1))
+	assert.Equal(t, "1", expvar.Get(prefix+".size").String())
+}
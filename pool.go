@@ -0,0 +1,38 @@
+package stablemap
+
+import "sync"
+
+// MapPool reuses maps of a fixed capacity through a sync.Pool, sparing the groups
+// allocation for workloads creating and discarding many short-lived maps.
+// It's safe for concurrent use.
+type MapPool[K comparable, V any] struct {
+	pool     sync.Pool
+	capacity uintptr
+}
+
+// Returns a new pool handing out maps created by New(capacity, opts...).
+func NewMapPool[K comparable, V any](capacity int, opts ...Option[K, V]) *MapPool[K, V] {
+	p := MapPool[K, V]{capacity: normalizeCapacity(capacity)}
+	p.pool.New = func() any {
+		return New(capacity, opts...)
+	}
+
+	return &p
+}
+
+// Returns an empty map from the pool, or a new one if the pool is empty.
+func (p *MapPool[K, V]) Get() *StableMap[K, V] {
+	return p.pool.Get().(*StableMap[K, V])
+}
+
+// Resets the map and returns it to the pool.
+// The map must not be used after that. Maps whose capacity changed since Get,
+// with Grow or Trim, are dropped rather than pooled.
+func (p *MapPool[K, V]) Put(sm *StableMap[K, V]) {
+	if sm.capacity != p.capacity {
+		return
+	}
+
+	sm.Reset()
+	p.pool.Put(sm)
+}
@@ -0,0 +1,33 @@
+package stablemap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapPool(t *testing.T) {
+	p := NewMapPool[int, int](100)
+
+	sm := p.Get()
+	assert.Equal(t, 128, sm.Stats().Capacity)
+
+	for i := range 50 {
+		require.NoError(t, sm.Set(i, i))
+	}
+	require.True(t, sm.Delete(0))
+	p.Put(sm)
+
+	// Whether it's the same map or a new one, it's empty
+	sm = p.Get()
+	assert.Zero(t, sm.Len())
+	assert.Zero(t, sm.Stats().Tombstones)
+	assert.False(t, sm.Contains(1))
+	assert.Equal(t, 128, sm.Stats().Capacity)
+
+	// Grown maps are dropped
+	require.NoError(t, sm.Grow(256))
+	p.Put(sm)
+	assert.Equal(t, 128, p.Get().Stats().Capacity)
+}
@@ -0,0 +1,54 @@
+package stablemap
+
+import "iter"
+
+// ReadOnlyMap is a StableMap frozen by StableMap.Freeze: it only provides lookups
+// and iteration, so it can't be modified by mistake. Since nothing writes to it,
+// it's safe for concurrent use by multiple goroutines without locking.
+type ReadOnlyMap[K comparable, V any] struct {
+	sm StableMap[K, V]
+}
+
+// Compacts the map, so that lookups don't walk past any tombstone, and returns
+// a read-only map taking over its storage, without copying it.
+// The map itself is left empty, with the same options and the smallest capacity,
+// one group: it grows back like any other map. Freeze a Snapshot instead to keep
+// a modifiable copy of the entries.
+func (sm *StableMap[K, V]) Freeze() *ReadOnlyMap[K, V] {
+	sm.completeCompaction()
+	if sm.tombstones > 0 {
+		sm.compact()
+	}
+
+	ro := &ReadOnlyMap[K, V]{sm: *sm}
+	*sm = StableMap[K, V]{}
+	sm.init(groupSize, ro.sm.options()...)
+
+	return ro
+}
+
+// Checks whether a key is in the map.
+func (ro *ReadOnlyMap[K, V]) Get(key K) (V, bool) {
+	return ro.sm.Get(key)
+}
+
+// Checks whether a key is in the map, without copying its value out like Get does.
+func (ro *ReadOnlyMap[K, V]) Contains(key K) bool {
+	return ro.sm.Contains(key)
+}
+
+// Returns the number of entries in the map.
+func (ro *ReadOnlyMap[K, V]) Len() int {
+	return ro.sm.Len()
+}
+
+// Returns an iterator over the entries of the map in no particular order,
+// see StableMap.All.
+func (ro *ReadOnlyMap[K, V]) All() iter.Seq2[K, V] {
+	return ro.sm.All()
+}
+
+// Returns the statistics of the frozen table, see StableMap.Stats.
+func (ro *ReadOnlyMap[K, V]) Stats() Stats {
+	return ro.sm.Stats()
+}
@@ -0,0 +1,66 @@
+package stablemap
+
+import (
+	"maps"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStableMap_Freeze(t *testing.T) {
+	sm := New(256, WithAutoCompact[int, int](1))
+	for i := range 200 {
+		require.NoError(t, sm.Set(i, i*10))
+	}
+	for i := 0; i < 200; i += 2 {
+		require.True(t, sm.Delete(i))
+	}
+	require.NotZero(t, sm.Stats().Tombstones)
+	want := sm.ToMap()
+
+	ro := sm.Freeze()
+	assert.Zero(t, ro.Stats().Tombstones)
+	assert.Equal(t, 100, ro.Len())
+	assert.Equal(t, want, maps.Collect(ro.All()))
+
+	// The map gave its storage away, and starts over empty with the same options
+	assert.Zero(t, sm.Len())
+	assert.Equal(t, groupSize, sm.Stats().Capacity)
+	assert.Equal(t, float32(1), sm.compactionThresholdRatio)
+	for i := range 300 {
+		require.NoError(t, sm.Reserve(1))
+		require.NoError(t, sm.Set(i, -i))
+	}
+	assert.Equal(t, 300, sm.Len())
+	require.NoError(t, sm.CheckInvariants())
+
+	// Without touching the frozen one
+	assert.Equal(t, 100, ro.Len())
+	assert.Equal(t, want, maps.Collect(ro.All()))
+
+	// Concurrent lookups need no locking
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Go(func() {
+			for i := range 200 {
+				v, ok := ro.Get(i)
+				assert.Equal(t, i%2 == 1, ok)
+				assert.Equal(t, i%2 == 1, ro.Contains(i))
+				if ok {
+					assert.Equal(t, i*10, v)
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	// Writes are impossible by construction
+	typ := reflect.TypeOf(ro)
+	for _, name := range []string{"Set", "Delete", "Grow", "Trim", "Reset", "Purge", "CompactStep", "GetPtr"} {
+		_, ok := typ.MethodByName(name)
+		assert.False(t, ok, name)
+	}
+}
@@ -0,0 +1,231 @@
+package stablemap
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"iter"
+	"strings"
+	"unicode"
+	"unsafe"
+)
+
+// StableSet is a set-like data structure sharing the StableMap swiss table,
+// with zero-sized values. Like StableMap, it retains the capacity it was
+// initialized with.
+//
+// StableSet is NOT safe for concurrent use.
+type StableSet[K comparable] struct {
+	table[K, struct{}]
+}
+
+// Returns a new instance of the stable set.
+// The capacity is rounded up the same way as for New.
+func NewSet[K comparable](capacity int, opts ...Option[K, struct{}]) *StableSet[K] {
+	var ss StableSet[K]
+	ss.init(capacity, opts...)
+
+	return &ss
+}
+
+// Returns a new set holding the distinct keys of the given slice.
+// The set is sized to fit all of them under the load factor.
+func FromSlice[K comparable](keys []K, opts ...Option[K, struct{}]) *StableSet[K] {
+	var ss StableSet[K]
+	ss.init(capacityForSize(len(keys)), opts...)
+
+	// A custom load factor may need more room than the default sizing gives.
+	// Growing an empty table can't fail, and neither can putting the keys afterwards,
+	// unless WithMaxProbe leaves some of them out.
+	_ = ss.reserve(len(keys))
+
+	for _, key := range keys {
+		_ = ss.set(key, struct{}{})
+	}
+
+	return &ss
+}
+
+// Returns a new set holding the lines read from r, one key per line.
+// Trailing whitespace is trimmed and blank lines are skipped.
+// The set starts with `capacityHint` slots and doubles its capacity
+// whenever it fills up before the input ends.
+func NewStringSetFromReader(r io.Reader, capacityHint int, opts ...Option[string, struct{}]) (*StableSet[string], error) {
+	ss := NewSet(capacityHint, opts...)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key := strings.TrimRightFunc(scanner.Text(), unicode.IsSpace)
+		if key == "" {
+			continue
+		}
+
+		err := ss.Put(key)
+		if errors.Is(err, ErrTableFull) {
+			if err = ss.grow(int(ss.capacity) * 2); err == nil {
+				err = ss.Put(key)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return ss, nil
+}
+
+// Checks whether a key is in the set.
+func (ss *StableSet[K]) Has(key K) bool {
+	_, ok := ss.get(key)
+	return ok
+}
+
+// Puts a key into the set.
+// Returns an error if the table is full.
+func (ss *StableSet[K]) Put(key K) error {
+	return ss.set(key, struct{}{})
+}
+
+// Deletes a key from the set.
+func (ss *StableSet[K]) Delete(key K) bool {
+	return ss.delete(key)
+}
+
+// Deletes an arbitrary key from the set and returns it.
+// Returns false if the set is empty.
+func (ss *StableSet[K]) Pop() (K, bool) {
+	key, _, ok := ss.pop()
+	return key, ok
+}
+
+// Deletes up to `n` arbitrary keys from the set and returns them.
+// Fewer keys are returned if the set holds less than `n`.
+// It's Pop for a batch of keys, walking the table once for the whole batch.
+func (ss *StableSet[K]) TakeN(n int) []K {
+	ss.completeCompaction()
+
+	keys := make([]K, 0, min(max(n, 0), int(ss.size)))
+
+	for i := 0; i < len(ss.groups) && len(keys) < cap(keys); i++ {
+		g := &ss.groups[i]
+		ctrl := *(*uint64)(unsafe.Pointer(&g.ctrls))
+
+		for matches := matchFull(ctrl); matches != 0 && len(keys) < cap(keys); matches = matches.removeFirst() {
+			idx := matches.first()
+			keys = append(keys, g.slots[idx])
+			g.ctrls[idx] = slotDeleted
+		}
+	}
+
+	// Compact once at the end rather than in the middle of the walk,
+	// compaction moves entries around.
+	if len(keys) > 0 {
+		ss.removed(uintptr(len(keys)))
+	}
+
+	return keys
+}
+
+// Returns the number of keys for which `pred` returns true.
+// It walks the whole table.
+func (ss *StableSet[K]) Count(pred func(key K) bool) int {
+	var n int
+	ss.all(func(key K, _ struct{}) bool {
+		if pred(key) {
+			n++
+		}
+		return true
+	})
+
+	return n
+}
+
+// Deletes every key for which `pred` returns true, e.g. to prune expired IDs.
+// Returns the number of deleted keys.
+// Like StableMap.DeleteIf, a sweep that leaves many tombstones behind
+// compacts the set right after it.
+// `pred` must not modify the set.
+func (ss *StableSet[K]) DeleteIf(pred func(key K) bool) int {
+	return ss.deleteIf(func(key K, _ struct{}) bool {
+		return pred(key)
+	})
+}
+
+// Drops every tombstone by re-inserting the keys into a new table of the same capacity,
+// and returns the number of tombstones dropped. It's a no-op returning 0 if the set has
+// none, the tombstone count is kept up to date by every delete.
+// Under WithMaxProbe, returns ErrCapacityTooSmall and leaves the set untouched
+// if the keys don't all fit the new layout.
+func (ss *StableSet[K]) Rehash() (reclaimed int, err error) {
+	ss.completeCompaction()
+
+	if ss.tombstones == 0 {
+		return 0, nil
+	}
+
+	reclaimed = int(ss.tombstones)
+	if err = ss.resize(int(ss.capacity)); err != nil {
+		return 0, err
+	}
+
+	return reclaimed, nil
+}
+
+// Returns the number of keys in the set.
+func (ss *StableSet[K]) Len() int {
+	return int(ss.size)
+}
+
+// Returns the keys of the set in no particular order.
+func (ss *StableSet[K]) ToSlice() []K {
+	keys := make([]K, 0, ss.size)
+	ss.all(func(key K, _ struct{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+
+	return keys
+}
+
+// Returns an iterator over the keys of the set in no particular order,
+// to be used with a for-range loop. Breaking out of the loop stops the walk.
+// The set must not be modified during the iteration: compaction and resizing
+// move keys around, so they may be skipped or yielded twice.
+func (ss *StableSet[K]) All() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		ss.all(func(key K, _ struct{}) bool {
+			return yield(key)
+		})
+	}
+}
+
+// Puts every given key into the set.
+// Returns the number of keys that weren't present. If the table fills up, it stops
+// at the first key that doesn't fit and returns ErrTableFull along with the number
+// of keys added so far.
+func (ss *StableSet[K]) AddAll(keys []K) (int, error) {
+	size := ss.size
+	_, err := ss.setMany(keys, nil)
+
+	return int(ss.size - size), err
+}
+
+// Deletes every given key from the set.
+// Returns the number of keys that were present.
+func (ss *StableSet[K]) RemoveAll(keys []K) int {
+	return ss.deleteMany(keys)
+}
+
+// Deletes every key that isn't in `other`, leaving the intersection of both sets in place.
+// Returns the number of deleted keys.
+// Each removed key leaves a tombstone behind. If a sweep creates many of them at once,
+// the set is compacted right after it.
+func (ss *StableSet[K]) RetainAll(other *StableSet[K]) int {
+	return ss.deleteIf(func(key K, _ struct{}) bool {
+		return !other.Has(key)
+	})
+}
@@ -0,0 +1,355 @@
+package stablemap
+
+import (
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStableSet(t *testing.T) {
+	ss := NewSet[int](64)
+
+	for i := range 40 {
+		require.NoError(t, ss.Put(i))
+	}
+	// Putting a present key is a no-op
+	require.NoError(t, ss.Put(0))
+	assert.Equal(t, 40, ss.Len())
+
+	for i := range 10 {
+		require.True(t, ss.Delete(i))
+	}
+	assert.False(t, ss.Delete(0))
+	assert.Equal(t, 30, ss.Len())
+	assert.Equal(t, 10, ss.Stats().Tombstones)
+
+	for i := range 40 {
+		assert.Equal(t, i >= 10, ss.Has(i))
+	}
+
+	require.NoError(t, ss.CheckInvariants())
+}
+
+func TestStableSet_Full(t *testing.T) {
+	ss := NewSet[int](16)
+
+	for i := range ss.Stats().EffectiveCapacity {
+		require.NoError(t, ss.Put(i))
+	}
+	require.ErrorIs(t, ss.Put(-1), ErrTableFull)
+}
+
+func TestStableSet_Stats(t *testing.T) {
+	ss := NewSet(64, WithAutoCompact[int, struct{}](1))
+
+	stats := ss.Stats()
+	assert.Zero(t, stats.Size)
+	assert.Zero(t, stats.Tombstones)
+	assert.Equal(t, 64, stats.Capacity)
+	assert.Equal(t, 64*7/8, stats.EffectiveCapacity)
+
+	for i := range 20 {
+		require.NoError(t, ss.Put(i))
+	}
+	assert.Equal(t, 20, ss.Stats().Size)
+	assert.Zero(t, ss.Stats().Tombstones)
+
+	// Deletes leave tombstones behind
+	for i := range 5 {
+		require.True(t, ss.Delete(i))
+	}
+	stats = ss.Stats()
+	assert.Equal(t, 15, stats.Size)
+	assert.Equal(t, 5, stats.Tombstones)
+	assert.Equal(t, float32(5)/float32(stats.EffectiveCapacity), stats.TombstonesCapacityRatio)
+	assert.Equal(t, float32(5)/float32(15), stats.TombstonesSizeRatio)
+
+	// Putting a key back reuses a tombstone
+	require.NoError(t, ss.Put(0))
+	assert.Equal(t, 16, ss.Stats().Size)
+	assert.Equal(t, 4, ss.Stats().Tombstones)
+
+	// Rehashing drops them, the capacity stays the same
+	_, err := ss.Rehash()
+	require.NoError(t, err)
+	stats = ss.Stats()
+	assert.Equal(t, 16, stats.Size)
+	assert.Zero(t, stats.Tombstones)
+	assert.Equal(t, 64, stats.Capacity)
+	assert.Equal(t, 64*7/8, stats.EffectiveCapacity)
+}
+
+func TestStableSet_FromSlice_ToSlice(t *testing.T) {
+	keys := make([]int, 0, 300)
+	for i := range 300 {
+		keys = append(keys, i%100)
+	}
+
+	ss := FromSlice(keys)
+	assert.Equal(t, 100, ss.Len())
+
+	got := ss.ToSlice()
+	assert.Len(t, got, 100)
+	assert.ElementsMatch(t, keys[:100], got)
+
+	assert.Empty(t, FromSlice[string](nil).ToSlice())
+}
+
+func TestStableSet_FromSlice_LoadFactor(t *testing.T) {
+	keys := make([]int, 1000)
+	for i := range keys {
+		keys[i] = i
+	}
+
+	ss := FromSlice(keys, WithLoadFactor[int, struct{}](0.5))
+	assert.Equal(t, 1000, ss.Len())
+	assert.GreaterOrEqual(t, ss.Stats().EffectiveCapacity, 1000)
+}
+
+func TestStableSet_AddAll(t *testing.T) {
+	ss := NewSet[int](16)
+	require.NoError(t, ss.Put(0))
+
+	added, err := ss.AddAll([]int{0, 1, 2, 2, 3})
+	require.NoError(t, err)
+	assert.Equal(t, 3, added)
+	assert.Equal(t, 4, ss.Len())
+
+	keys := make([]int, 20)
+	for i := range keys {
+		keys[i] = i
+	}
+
+	added, err = ss.AddAll(keys)
+	require.ErrorIs(t, err, ErrTableFull)
+	assert.Equal(t, ss.Stats().EffectiveCapacity-4, added)
+	assert.Equal(t, ss.Stats().EffectiveCapacity, ss.Len())
+
+	for i := range ss.Len() {
+		assert.True(t, ss.Has(i))
+	}
+}
+
+func TestStableSet_RemoveAll(t *testing.T) {
+	ss := FromSlice([]int{1, 2, 3, 4, 5})
+
+	assert.Equal(t, 2, ss.RemoveAll([]int{1, 3, 3, 10}))
+	assert.Equal(t, 3, ss.Len())
+	assert.ElementsMatch(t, []int{2, 4, 5}, ss.ToSlice())
+
+	assert.Zero(t, ss.RemoveAll(nil))
+}
+
+func TestStableSet_RetainAll(t *testing.T) {
+	ss := FromSlice([]int{1, 2, 3, 4, 5, 6})
+
+	assert.Equal(t, 3, ss.RetainAll(FromSlice([]int{2, 4, 6, 8})))
+	assert.ElementsMatch(t, []int{2, 4, 6}, ss.ToSlice())
+
+	// Retaining against itself changes nothing
+	assert.Zero(t, ss.RetainAll(ss))
+	assert.Equal(t, 3, ss.Len())
+
+	assert.Equal(t, 3, ss.RetainAll(FromSlice([]int{7, 9})))
+	assert.Zero(t, ss.Len())
+	require.NoError(t, ss.CheckInvariants())
+}
+
+func TestStableSet_DeleteIf(t *testing.T) {
+	ss := NewSet[int](128)
+	for i := range 100 {
+		require.NoError(t, ss.Put(i))
+	}
+
+	assert.Equal(t, 34, ss.DeleteIf(func(key int) bool { return key%3 == 0 }))
+	assert.Equal(t, 66, ss.Len())
+	for i := range 100 {
+		assert.Equal(t, i%3 != 0, ss.Has(i))
+	}
+
+	assert.Zero(t, ss.DeleteIf(func(key int) bool { return key%3 == 0 }))
+	require.NoError(t, ss.CheckInvariants())
+}
+
+func TestStableSet_Pop(t *testing.T) {
+	ss := NewSet[int](256)
+	for i := range 200 {
+		require.NoError(t, ss.Put(i))
+	}
+
+	seen := make(map[int]struct{}, 200)
+	for {
+		key, ok := ss.Pop()
+		if !ok {
+			break
+		}
+
+		require.NotContains(t, seen, key)
+		require.False(t, ss.Has(key))
+		seen[key] = struct{}{}
+	}
+
+	assert.Len(t, seen, 200)
+	assert.Zero(t, ss.Len())
+
+	key, ok := ss.Pop()
+	assert.False(t, ok)
+	assert.Zero(t, key)
+}
+
+func TestStableSet_Count(t *testing.T) {
+	ss := NewSet[int](128)
+	for i := range 100 {
+		require.NoError(t, ss.Put(i))
+	}
+	require.True(t, ss.Delete(0))
+
+	assert.Equal(t, 49, ss.Count(func(key int) bool { return key%2 == 0 }))
+	assert.Zero(t, NewSet[int](8).Count(func(int) bool { return true }))
+}
+
+func TestStableSet_All(t *testing.T) {
+	ss := NewSet[int](128)
+	for i := range 100 {
+		require.NoError(t, ss.Put(i))
+	}
+	for i := 0; i < 100; i += 3 {
+		require.True(t, ss.Delete(i))
+	}
+
+	var want []int
+	for i := range 100 {
+		if i%3 != 0 {
+			want = append(want, i)
+		}
+	}
+
+	assert.ElementsMatch(t, want, slices.Collect(ss.All()))
+
+	// Breaking out stops the walk
+	var n int
+	for range ss.All() {
+		n++
+		if n == 5 {
+			break
+		}
+	}
+	assert.Equal(t, 5, n)
+}
+
+func TestStableSet_Compact_Full(t *testing.T) {
+	// A weak hash piling keys up in a few home groups, so that compaction
+	// has to move most of them around
+	ss := NewSet(64, WithHashFunc[int, struct{}](func(k int) uint64 {
+		return uint64(k%3)<<10 | uint64(k&0x7F)
+	}), WithAutoCompact[int, struct{}](1))
+
+	for i := range ss.Stats().EffectiveCapacity {
+		require.NoError(t, ss.Put(i))
+	}
+	for i := range 10 {
+		require.True(t, ss.Delete(i*5))
+	}
+
+	ss.compact()
+	require.NoError(t, ss.CheckInvariants())
+	assert.Zero(t, ss.Stats().Tombstones)
+
+	for i := range ss.Stats().EffectiveCapacity {
+		assert.Equal(t, i%5 != 0 || i >= 50, ss.Has(i), "key %d", i)
+	}
+}
+
+func TestStableSet_Rehash(t *testing.T) {
+	ss := NewSet(64, WithAutoCompact[int, struct{}](1))
+	for i := range 40 {
+		require.NoError(t, ss.Put(i))
+	}
+
+	// Nothing to reclaim, the storage isn't even reallocated
+	groups := &ss.groups[0]
+	reclaimed, err := ss.Rehash()
+	require.NoError(t, err)
+	assert.Zero(t, reclaimed)
+	assert.Same(t, groups, &ss.groups[0])
+
+	for i := range 15 {
+		require.True(t, ss.Delete(i))
+	}
+
+	reclaimed, err = ss.Rehash()
+	require.NoError(t, err)
+	assert.Equal(t, 15, reclaimed)
+	assert.Zero(t, ss.Stats().Tombstones)
+	assert.Equal(t, 25, ss.Len())
+	for i := range 40 {
+		assert.Equal(t, i >= 15, ss.Has(i))
+	}
+	require.NoError(t, ss.CheckInvariants())
+}
+
+func TestStableSet_TakeN(t *testing.T) {
+	ss := NewSet[int](2048)
+	for i := range 1000 {
+		require.NoError(t, ss.Put(i))
+	}
+
+	seen := make(map[int]struct{}, 1000)
+	for ss.Len() > 0 {
+		keys := ss.TakeN(100)
+		require.Len(t, keys, 100)
+
+		for _, key := range keys {
+			require.NotContains(t, seen, key)
+			require.False(t, ss.Has(key))
+			seen[key] = struct{}{}
+		}
+
+		require.NoError(t, ss.CheckInvariants())
+	}
+	assert.Len(t, seen, 1000)
+
+	assert.Empty(t, ss.TakeN(100))
+
+	require.NoError(t, ss.Put(1))
+	assert.Equal(t, []int{1}, ss.TakeN(100))
+	assert.Empty(t, ss.TakeN(-1))
+}
+
+func TestNewStringSetFromReader(t *testing.T) {
+	input := "foo\nbar  \n\n   \nbaz\t\r\nfoo\n  qux\n"
+
+	ss, err := NewStringSetFromReader(strings.NewReader(input), 8)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"foo", "bar", "baz", "  qux"}, ss.ToSlice())
+
+	// The hint is too small, the set grows as needed
+	var b strings.Builder
+	for i := range 1000 {
+		b.WriteString(strconv.Itoa(i))
+		b.WriteByte('\n')
+	}
+
+	ss, err = NewStringSetFromReader(strings.NewReader(b.String()), 16)
+	require.NoError(t, err)
+	assert.Equal(t, 1000, ss.Len())
+	for i := range 1000 {
+		assert.True(t, ss.Has(strconv.Itoa(i)))
+	}
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+func TestNewStringSetFromReader_Error(t *testing.T) {
+	_, err := NewStringSetFromReader(errReader{}, 16)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,148 @@
+package stablemap
+
+import (
+	"runtime"
+	"slices"
+)
+
+// ShardedMap is a concurrent map that spreads keys over several independently
+// locked StableMaps, so writers to different shards don't contend on a single lock.
+//
+// Every shard shares the same hash function: a key is hashed once, and that hash
+// both selects the shard and drives the lookup inside it.
+// Each shard holds an equal share of the requested capacity, so a skewed key
+// distribution may fill one shard (and return ErrTableFull) before the others.
+type ShardedMap[K comparable, V any] struct {
+	shards   []shard[K, V]
+	hashFunc HashFunc[K]
+}
+
+// cacheLineSize is the padding between shards, the cache line size of most
+// amd64 and arm64 CPUs.
+const cacheLineSize = 64
+
+// shard is a SyncMap followed by a cache line of padding, so that the counters at
+// the end of one shard (size, tombstones) and the lock at the start of the next one
+// don't share a cache line. Whether that matters depends on the number of cores
+// writing at once, see BenchmarkShardedMap_DistinctShards_Padded.
+type shard[K comparable, V any] struct {
+	SyncMap[K, V]
+	_ [cacheLineSize]byte
+}
+
+type shardedConfig[K comparable, V any] struct {
+	shards int
+	opts   []Option[K, V]
+}
+
+type ShardedOption[K comparable, V any] func(c *shardedConfig[K, V])
+
+// WithShards sets the number of shards. Default is runtime.GOMAXPROCS(0).
+func WithShards[K comparable, V any](n int) ShardedOption[K, V] {
+	return func(c *shardedConfig[K, V]) {
+		if n > 0 {
+			c.shards = n
+		}
+	}
+}
+
+// WithShardOptions sets the options every shard is created with.
+func WithShardOptions[K comparable, V any](opts ...Option[K, V]) ShardedOption[K, V] {
+	return func(c *shardedConfig[K, V]) {
+		c.opts = append(c.opts, opts...)
+	}
+}
+
+// Returns a new instance of the sharded map.
+// The capacity is split evenly between the shards.
+func NewShardedMap[K comparable, V any](capacity int, opts ...ShardedOption[K, V]) *ShardedMap[K, V] {
+	c := shardedConfig[K, V]{shards: runtime.GOMAXPROCS(0)}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	m := ShardedMap[K, V]{shards: make([]shard[K, V], c.shards)}
+	m.hashFunc = initPartitions(len(m.shards), capacity, c.opts, func(i int) *table[K, V] {
+		return &m.shards[i].sm.table
+	})
+
+	return &m
+}
+
+// initPartitions initializes the `n` tables returned by `at`, each with an equal share
+// of the capacity, and returns the hash function they share.
+// The first table resolves it (a custom one or the default with a random seed),
+// then every other table is pinned to the same one.
+func initPartitions[K comparable, V any](n, capacity int, opts []Option[K, V], at func(i int) *table[K, V]) HashFunc[K] {
+	partitionCapacity := (capacity + n - 1) / n
+
+	at(0).init(partitionCapacity, opts...)
+	hashFunc := at(0).hashFunc
+
+	// A new slice, appending could write into the caller's array
+	pinnedOpts := slices.Concat(opts, []Option[K, V]{WithHashFunc[K, V](hashFunc)})
+	for i := 1; i < n; i++ {
+		at(i).init(partitionCapacity, pinnedOpts...)
+	}
+
+	return hashFunc
+}
+
+// shard returns the shard responsible for the given hash.
+func (m *ShardedMap[K, V]) shard(hash uint64) *SyncMap[K, V] {
+	return &m.shards[partition(hash, len(m.shards))].SyncMap
+}
+
+// partition maps a hash to one of n partitions.
+// It's picked from the upper 32 bits, which the inner table doesn't use for
+// group selection (unless it has more than 2^22 groups), keeping the two independent.
+func partition(hash uint64, n int) int {
+	return int(((hash >> 32) * uint64(n)) >> 32)
+}
+
+// Checks whether a key is in the map.
+func (m *ShardedMap[K, V]) Get(key K) (V, bool) {
+	hash := m.hashFunc(key)
+	s := m.shard(hash)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.sm.getHashed(key, hash)
+}
+
+// Sets a key in the map.
+// If the key is already present, overwrites it.
+// Returns an error if the key's shard is full.
+func (m *ShardedMap[K, V]) Set(key K, value V) error {
+	hash := m.hashFunc(key)
+	s := m.shard(hash)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sm.setHashed(key, hash, value)
+}
+
+// Deletes a key from the map.
+func (m *ShardedMap[K, V]) Delete(key K) bool {
+	hash := m.hashFunc(key)
+	s := m.shard(hash)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sm.deleteHashed(key, hash)
+}
+
+// Returns the number of entries in the map.
+// Shards are summed one at a time, so the result is not a consistent snapshot
+// while writers are active.
+func (m *ShardedMap[K, V]) Len() int {
+	var n int
+	for i := range m.shards {
+		n += m.shards[i].Len()
+	}
+
+	return n
+}
@@ -0,0 +1,103 @@
+package stablemap
+
+import (
+	"sync"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardedMap_Basic(t *testing.T) {
+	m := NewShardedMap(1024, WithShards[int, int](8))
+	require.Len(t, m.shards, 8)
+
+	for i := range 500 {
+		require.NoError(t, m.Set(i, i*10))
+	}
+	assert.Equal(t, 500, m.Len())
+
+	for i := range 500 {
+		v, ok := m.Get(i)
+		require.True(t, ok)
+		assert.Equal(t, i*10, v)
+	}
+
+	for i := range 250 {
+		assert.True(t, m.Delete(i))
+	}
+	assert.Equal(t, 250, m.Len())
+
+	_, ok := m.Get(0)
+	assert.False(t, ok)
+
+	// Keys are actually spread over the shards
+	for i := range m.shards {
+		assert.Positive(t, m.shards[i].Len())
+	}
+}
+
+func TestShardedMap_SharedHashFunc(t *testing.T) {
+	var calls int
+	hashFunc := func(k int) uint64 {
+		calls++
+		return uint64(k) * 0x9E3779B97F4A7C15
+	}
+
+	m := NewShardedMap(64, WithShards[int, int](4), WithShardOptions(WithHashFunc[int, int](hashFunc)))
+
+	for i := range m.shards {
+		require.NotNil(t, m.shards[i].sm.hashFunc)
+	}
+
+	// The key is hashed once per operation
+	require.NoError(t, m.Set(1, 1))
+	assert.Equal(t, 1, calls)
+
+	_, ok := m.Get(1)
+	assert.True(t, ok)
+	assert.Equal(t, 2, calls)
+}
+
+func TestShardedMap_Concurrent(t *testing.T) {
+	const (
+		workers = 8
+		keys    = 1024
+	)
+
+	m := NewShardedMap(workers*keys*2, WithShards[int, int](16))
+
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range keys {
+				key := w*keys + i
+				assert.NoError(t, m.Set(key, key))
+
+				v, ok := m.Get(key)
+				assert.True(t, ok)
+				assert.Equal(t, key, v)
+
+				if i%2 == 0 {
+					assert.True(t, m.Delete(key))
+				}
+				_ = m.Len()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, workers*keys/2, m.Len())
+}
+
+func TestShardedMap_Padding(t *testing.T) {
+	// Adjacent shards' SyncMaps are at least a cache line apart
+	var s [2]shard[uint64, uint64]
+	gap := uintptr(unsafe.Pointer(&s[1].SyncMap)) - uintptr(unsafe.Pointer(&s[0].SyncMap)) - unsafe.Sizeof(s[0].SyncMap)
+	assert.GreaterOrEqual(t, gap, uintptr(cacheLineSize))
+}
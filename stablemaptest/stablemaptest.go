@@ -0,0 +1,39 @@
+// Package stablemaptest provides helpers for testing code built on stablemap.
+package stablemaptest
+
+import (
+	"testing"
+
+	"github.com/homier/stablemap"
+)
+
+// AssertEquivalent checks that sm holds exactly the entries of ref, and that its
+// internal invariants hold. Every violation is reported with t.Errorf.
+//
+// It's meant for differential tests: apply the same operations to a StableMap
+// and a standard map, then compare them after every step.
+func AssertEquivalent[K comparable, V comparable](t testing.TB, sm *stablemap.StableMap[K, V], ref map[K]V) {
+	t.Helper()
+
+	if err := sm.CheckInvariants(); err != nil {
+		t.Errorf("broken invariant: %v", err)
+	}
+
+	if sm.Len() != len(ref) {
+		t.Errorf("map has %d entries, want %d", sm.Len(), len(ref))
+	}
+
+	// With matching lengths and no duplicates (see CheckInvariants),
+	// finding every reference entry also rules out extra ones.
+	for key, want := range ref {
+		got, ok := sm.Get(key)
+		if !ok {
+			t.Errorf("key %v is missing", key)
+			continue
+		}
+
+		if got != want {
+			t.Errorf("key %v has value %v, want %v", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package stablemaptest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/homier/stablemap"
+)
+
+func TestAssertEquivalent(t *testing.T) {
+	sm := stablemap.New[string, int](16)
+	ref := map[string]int{"foo": 1, "bar": 2}
+
+	for k, v := range ref {
+		if err := sm.Set(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	AssertEquivalent(t, sm, ref)
+
+	for _, other := range []map[string]int{
+		{"foo": 1},
+		{"foo": 1, "bar": 3},
+		{"foo": 1, "baz": 2},
+	} {
+		rec := &recorder{TB: t}
+		AssertEquivalent(rec, sm, other)
+		if !rec.failed {
+			t.Errorf("AssertEquivalent passed against %v", other)
+		}
+	}
+}
+
+// recorder records failures instead of failing the test.
+type recorder struct {
+	testing.TB
+	failed bool
+}
+
+func (r *recorder) Errorf(string, ...any) {
+	r.failed = true
+}
+
+// FuzzEquivalence applies the same operations to a StableMap and a standard map,
+// two bytes per operation: the operation and the key.
+func FuzzEquivalence(f *testing.F) {
+	// Overwrites and deletes of absent keys
+	f.Add([]byte{0, 1, 0, 1, 2, 1, 2, 5, 1, 1, 0, 2})
+	// Fill up to ErrTableFull, delete everything, then refill reusing the tombstones
+	fill := make([]byte, 0, 6*64)
+	for _, op := range []byte{0, 2, 0} {
+		for k := range byte(64) {
+			fill = append(fill, op, k)
+		}
+	}
+	f.Add(fill)
+	// Churn in a single home group with compactions and a grow in between
+	f.Add([]byte{0, 8, 0, 16, 0, 24, 2, 16, 3, 0, 0, 32, 2, 8, 4, 0, 0, 40, 1, 24, 3, 0})
+
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		// A weak hash piling keys up into 8 home groups
+		sm := stablemap.New(32, stablemap.WithHashFunc[uint8, int](func(k uint8) uint64 {
+			return uint64(k%8)<<10 | uint64(k)
+		}))
+		ref := make(map[uint8]int)
+
+		for i := 0; i+1 < len(ops); i += 2 {
+			key := ops[i+1] % 64
+
+			switch ops[i] % 5 {
+			case 0:
+				if err := sm.Set(key, i); err != nil {
+					if !errors.Is(err, stablemap.ErrTableFull) || len(ref) != sm.Stats().EffectiveCapacity {
+						t.Fatalf("set %d: unexpected error %v with %d entries", key, err, len(ref))
+					}
+					break
+				}
+				ref[key] = i
+			case 1:
+				want, wantOK := ref[key]
+				if got, ok := sm.Get(key); ok != wantOK || got != want {
+					t.Fatalf("get %d: got (%d, %t), want (%d, %t)", key, got, ok, want, wantOK)
+				}
+			case 2:
+				_, ok := ref[key]
+				if sm.Delete(key) != ok {
+					t.Fatalf("delete %d: got %t, want %t", key, !ok, ok)
+				}
+				delete(ref, key)
+			case 3:
+				sm.CompactStable()
+			case 4:
+				// Bounded, so that the map stays small enough to check after every step
+				if capacity := sm.Stats().Capacity; capacity < 256 {
+					if err := sm.Grow(capacity * 2); err != nil {
+						t.Fatalf("grow: %v", err)
+					}
+				}
+			}
+
+			AssertEquivalent(t, sm, ref)
+			if t.Failed() {
+				t.FailNow()
+			}
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package stablemap
+
+import "sync"
+
+// SyncMap is a StableMap guarded by a single sync.RWMutex, so it's safe for concurrent use.
+// Lookups take the read lock and may run in parallel, mutations take the write lock.
+type SyncMap[K comparable, V any] struct {
+	mu sync.RWMutex
+	sm StableMap[K, V]
+}
+
+// Returns a new instance of the synchronized stable map.
+func NewSyncMap[K comparable, V any](capacity int, opts ...Option[K, V]) *SyncMap[K, V] {
+	var m SyncMap[K, V]
+	m.sm.init(capacity, opts...)
+
+	return &m
+}
+
+// Checks whether a key is in the map.
+func (m *SyncMap[K, V]) Get(key K) (V, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.sm.Get(key)
+}
+
+// Sets a key in the map.
+// If the key is already present, overwrites it.
+// Returns an error if the table is full.
+func (m *SyncMap[K, V]) Set(key K, value V) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.sm.Set(key, value)
+}
+
+// Deletes a key from the map.
+func (m *SyncMap[K, V]) Delete(key K) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.sm.Delete(key)
+}
+
+// Returns the number of entries in the map.
+func (m *SyncMap[K, V]) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.sm.Len()
+}
+
+// Do runs fn under the write lock, which makes compound operations such as
+// get-or-set atomic. The map must not be retained after fn returns.
+func (m *SyncMap[K, V]) Do(fn func(sm *StableMap[K, V])) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fn(&m.sm)
+}
@@ -0,0 +1,130 @@
+package stablemap
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncMap_Basic(t *testing.T) {
+	m := NewSyncMap[string, int](16)
+
+	require.NoError(t, m.Set("foo", 42))
+
+	v, ok := m.Get("foo")
+	require.True(t, ok)
+	assert.Equal(t, 42, v)
+	assert.Equal(t, 1, m.Len())
+
+	assert.True(t, m.Delete("foo"))
+	assert.Equal(t, 0, m.Len())
+}
+
+func TestSyncMap_Concurrent(t *testing.T) {
+	const (
+		writers = 4
+		readers = 8
+		keys    = 512
+	)
+
+	m := NewSyncMap[int, int](writers * keys * 2)
+
+	var wg sync.WaitGroup
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range keys {
+				key := w*keys + i
+				assert.NoError(t, m.Set(key, key))
+
+				if i%4 == 0 {
+					m.Delete(key)
+				}
+			}
+		}()
+	}
+
+	for range readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range writers * keys {
+				if v, ok := m.Get(i); ok {
+					assert.Equal(t, i, v)
+				}
+				_ = m.Len()
+			}
+		}()
+	}
+
+	// Compound get-or-set from many goroutines must only insert once
+	var inserted sync.Map
+	for g := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			m.Do(func(sm *StableMap[int, int]) {
+				if _, ok := sm.Get(-1); !ok {
+					assert.NoError(t, sm.Set(-1, g))
+					inserted.Store(g, true)
+				}
+			})
+		}()
+	}
+
+	wg.Wait()
+
+	count := 0
+	inserted.Range(func(_, _ any) bool {
+		count++
+		return true
+	})
+	assert.Equal(t, 1, count)
+
+	// Every fourth key was deleted, plus the get-or-set key
+	assert.Equal(t, writers*keys*3/4+1, m.Len())
+}
+
+func TestSyncMap_GetDuringCompaction(t *testing.T) {
+	m := NewSyncMap(1024, WithAutoCompact[int, int](1))
+	for i := range 800 {
+		require.NoError(t, m.Set(i, i))
+	}
+	for i := 0; i < 800; i += 2 {
+		require.True(t, m.Delete(i))
+	}
+
+	// Leave a compaction pending: Get only takes the read lock and must not complete it
+	m.Do(func(sm *StableMap[int, int]) {
+		require.False(t, sm.CompactStep(1))
+		require.False(t, sm.CompactStep(100))
+	})
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Go(func() {
+			for i := range 800 {
+				v, ok := m.Get(i)
+				assert.Equal(t, i%2 == 1, ok)
+				if ok {
+					assert.Equal(t, i, v)
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	m.Do(func(sm *StableMap[int, int]) {
+		assert.True(t, sm.compacting)
+		for !sm.CompactStep(100) {
+		}
+		require.NoError(t, sm.CheckInvariants())
+	})
+	assert.Equal(t, 400, m.Len())
+}
@@ -2,23 +2,47 @@ package stablemap
 
 import (
 	"errors"
+	"fmt"
 	"hash/maphash"
+	"math/bits"
+	"slices"
+	"strings"
+	"sync"
 	"unsafe"
 )
 
-var ErrTableFull = errors.New("table is full")
+var (
+	ErrTableFull        = errors.New("table is full")
+	ErrCapacityTooSmall = errors.New("capacity is too small to hold the current entries")
+	ErrLengthMismatch   = errors.New("keys and values have different lengths")
+	ErrChecksumMismatch = errors.New("checksum mismatch, the data is corrupted")
+	ErrInvalidFormat    = errors.New("invalid binary format")
+	ErrInvalidCapacity  = errors.New("invalid capacity")
+	ErrValueTooLarge    = errors.New("value type is too large")
+)
 
 type Stats struct {
 	Size                    int
+	Capacity                int
 	EffectiveCapacity       int
 	Tombstones              int
 	TombstonesCapacityRatio float32
 	TombstonesSizeRatio     float32
+	// PeakProbeLength is the largest number of groups an insert has walked to place
+	// a new key since the table was allocated or Reset: a running maximum, which
+	// deletes and compactions don't lower. Resizing recomputes it while re-inserting.
+	PeakProbeLength int
 }
 
+// Control bytes. A live slot holds the h2 of its key (7 bits) with the MSB set,
+// empty and deleted slots have the MSB clear. Empty is zero, so that freshly
+// allocated groups are empty without writing to them, see WithLazyInit.
+// Pending only exists during a compaction, marking the live slots not relocated yet.
 const (
-	slotEmpty   = 0x80
-	slotDeleted = 0xFE
+	slotEmpty   = 0x00
+	slotPending = 0x40
+	slotDeleted = 0x7E
+	slotFull    = 0x80
 )
 
 var (
@@ -37,16 +61,50 @@ var (
 
 const defaultCompactionThresholdFactor = 3
 
+// maxValueSize is the largest value type accepted under WithStrictValueSize.
+const maxValueSize = 64
+
 type table[K comparable, V any] struct {
 	groups []group[K, V]
-
-	capacity                     uintptr
-	numGroupsMask                uintptr
+	// hashes caches the full hash of every slot's key, indexed by group*groupSize+slot.
+	// It's only allocated with WithCachedHashes.
+	hashes []uint64
+
+	capacity      uintptr
+	numGroupsMask uintptr
+	// probeLimit is the last step of a probe sequence: numGroupsMask to visit
+	// every group, or less with WithMaxProbe.
+	probeLimit                   uintptr
+	maxProbe                     uintptr
 	capacityEffective            uintptr
 	tombstoneCompactionThreshold uintptr
 	compactionThresholdFactor    uintptr
-	size                         uintptr
-	tombstones                   uintptr
+	compactionThresholdRatio     float32
+	loadFactor                   float64
+	probeAccel                   uintptr
+	cacheHashes                  bool
+	lazyInit                     bool
+	backshiftDelete              bool
+	strictValueSize              bool
+	compacting                   bool
+	compactionPolicyEvery        uintptr
+	mutations                    uintptr
+	compactPos                   uintptr
+	autoTrimLoadFactor           float32
+	// lowLoadDeletes counts the deletes in a row that left the load below
+	// autoTrimLoadFactor, see WithAutoTrim.
+	lowLoadDeletes uintptr
+	// strideMask selects the bits of h1 making the probe stride, see WithDoubleHashing.
+	strideMask uintptr
+
+	compactionPolicy func(stats Stats) bool
+
+	// afterInit runs once the table is allocated, see WithInitialData.
+	afterInit  func(t *table[K, V]) error
+	size       uintptr
+	tombstones uintptr
+	// peakProbeLength is the running maximum reported by Stats.PeakProbeLength.
+	peakProbeLength uintptr
 
 	hashFunc HashFunc[K]
 
@@ -73,32 +131,336 @@ func WithCompactionThresholdFactor[K comparable, V any](factor int) Option[K, V]
 	}
 }
 
+// WithAutoCompact sets the tombstone ratio at which Delete compacts the table:
+// compaction runs as soon as Stats().TombstonesCapacityRatio exceeds maxTombstoneRatio.
+// It must be in (0, 1], other values are ignored.
+// It's an alternative to WithCompactionThresholdFactor and takes precedence over it.
+func WithAutoCompact[K comparable, V any](maxTombstoneRatio float32) Option[K, V] {
+	return func(t *table[K, V]) {
+		if maxTombstoneRatio > 0 && maxTombstoneRatio <= 1 {
+			t.compactionThresholdRatio = maxTombstoneRatio
+		}
+	}
+}
+
+// WithAutoTrim shrinks the table after deletes once its load, Stats().Size/Capacity,
+// stays below minLoadFactor: the table is trimmed to about twice its current size,
+// leaving room for the population to double before Set fails with ErrTableFull.
+// It must be in (0, 1), other values are ignored.
+//
+// To avoid shrinking back and forth with a population hovering around the threshold,
+// the load must stay below it for capacity/16 deletes in a row, with no new key
+// inserted in between. Trimming moves every live entry into a new allocation,
+// like Trim does.
+func WithAutoTrim[K comparable, V any](minLoadFactor float32) Option[K, V] {
+	return func(t *table[K, V]) {
+		if minLoadFactor > 0 && minLoadFactor < 1 {
+			t.autoTrimLoadFactor = minLoadFactor
+		}
+	}
+}
+
+// WithLoadFactor sets the maximum fraction of slots that may hold entries before
+// Set returns ErrTableFull. It must be in (0, 1), other values are ignored.
+// Default is 7/8 (87.5%).
+//
+// A lower load factor keeps probe chains short, which speeds up write-heavy workloads
+// and lookups of missing keys, at the cost of more memory per stored entry:
+// at 0.5 half of the allocated slots always stay unused.
+func WithLoadFactor[K comparable, V any](f float64) Option[K, V] {
+	return func(t *table[K, V]) {
+		if f > 0 && f < 1 {
+			t.loadFactor = f
+		}
+	}
+}
+
+// WithNoLoadFactorLimit lets every slot hold an entry: Set returns ErrTableFull only
+// once the table is completely full. It's meant for lookup tables built once with
+// a known number of entries and rarely modified afterwards.
+//
+// The default 7/8 load factor keeps one slot per group free on average, which is what
+// stops most lookups of missing keys early. As the fill approaches 100%, groups with
+// an empty slot become rare, and such lookups, as well as inserts, walk long probe
+// sequences, up to every group of a full table.
+func WithNoLoadFactorLimit[K comparable, V any]() Option[K, V] {
+	return func(t *table[K, V]) {
+		t.loadFactor = 1
+	}
+}
+
+// WithLinearProbe switches the probe sequence from quadratic to linear:
+// groups are visited one after another from the key's home group.
+//
+// Linear probing has better cache locality, consecutive groups are adjacent in memory,
+// and may win with a well-distributed hash. It's prone to clustering though, so probe
+// chains grow quickly with a weak hash or a high load factor.
+func WithLinearProbe[K comparable, V any]() Option[K, V] {
+	return func(t *table[K, V]) {
+		t.probeAccel = 0
+		t.strideMask = 0
+	}
+}
+
+// WithDoubleHashing switches the probe sequence from quadratic to double hashing:
+// groups are visited at a constant stride from the key's home group, the stride being
+// derived from the high bits of the key's hash. Keys sharing a home group then follow
+// different sequences, unlike with quadratic or linear probing, which spreads the
+// collisions of hashes that are weak in their low bits.
+// The stride is odd, so that every group is visited within a full probe sequence.
+// It doesn't help keys whose hashes collide entirely, and loses the cache locality
+// of visiting nearby groups first.
+//
+// It can't be combined with WithLinearProbe and WithBackshiftDelete:
+// the last of these options wins.
+func WithDoubleHashing[K comparable, V any]() Option[K, V] {
+	return func(t *table[K, V]) {
+		t.probeAccel = 0
+		t.strideMask = ^uintptr(0)
+		t.backshiftDelete = false
+	}
+}
+
+// WithBackshiftDelete makes Delete leave no tombstone behind: the entries whose probe
+// chain passes through the freed slot are shifted back into it, one after another,
+// like in Robin Hood hashing, and the slot left over at the end is marked empty.
+// Lookups don't slow down with churn then, and the table never needs compacting
+// because of deletes, at the cost of deletes that walk the rest of the probe chain.
+//
+// It implies WithLinearProbe: the entries that may fill a slot all sit in the run of
+// groups following it, up to the first group with an empty slot.
+// Bulk deletions (DeleteIf, RetainAll, TakeN) still leave tombstones, and compact
+// the table like without this option.
+func WithBackshiftDelete[K comparable, V any]() Option[K, V] {
+	return func(t *table[K, V]) {
+		t.probeAccel = 0
+		t.strideMask = 0
+		t.backshiftDelete = true
+	}
+}
+
+// WithMaxProbe bounds probe sequences to `n` groups (of 8 slots each), trading fill
+// for worst-case latency: Set returns ErrTableFull for a new key when none of the
+// first `n` groups of its probe sequence has room, even if other groups do.
+// Lookups and deletes give up after `n` groups as well. It must be positive,
+// other values are ignored.
+//
+// Every insertion is subject to the bound. Grow and Trim fail and leave the map
+// untouched if the entries don't fit under it in the new layout. FromSlice and Filter
+// leave out the entries that don't fit, compare their Len to what's expected.
+//
+// The in-place compaction may move an entry past the bound, so every compaction,
+// including CompactStep, re-inserts the entries into a new table of the same capacity
+// instead. If they don't all fit, the tombstones are kept, and the automatic
+// compaction waits for as many tombstones again before retrying.
+func WithMaxProbe[K comparable, V any](n int) Option[K, V] {
+	return func(t *table[K, V]) {
+		if n > 0 {
+			t.maxProbe = uintptr(n)
+		}
+	}
+}
+
+// WithCompactionPolicy calls `decide` with the table's Stats after every `every`
+// mutations (inserts, overwrites and deletes), and compacts the table if it returns true.
+// Writes through a pointer returned by GetPtr aren't counted, and neither are
+// the entries moved by Grow, Trim or a compaction.
+// It runs on top of the tombstone threshold, which WithAutoCompact(1) disables.
+// `every` must be positive, otherwise the option is ignored.
+// `decide` must not modify the map.
+func WithCompactionPolicy[K comparable, V any](every int, decide func(stats Stats) bool) Option[K, V] {
+	return func(t *table[K, V]) {
+		if every > 0 && decide != nil {
+			t.compactionPolicyEvery = uintptr(every)
+			t.compactionPolicy = decide
+		}
+	}
+}
+
+// WithCachedHashes stores the full hash of every key next to the table, so that
+// compaction moves entries without hashing their keys again. It pays off for keys
+// that are expensive to hash, like long strings, and costs 8 bytes per slot.
+func WithCachedHashes[K comparable, V any]() Option[K, V] {
+	return func(t *table[K, V]) {
+		t.cacheHashes = true
+	}
+}
+
+// WithLazyInit leaves new groups as the runtime allocates them. Empty slots are
+// zero, so zeroed groups are already empty: the pages of a large map that's only
+// sparsely filled aren't touched until entries are stored in them. Without it, every
+// control byte is written at allocation, which makes the memory resident up front
+// and spares the first inserts the page faults.
+func WithLazyInit[K comparable, V any]() Option[K, V] {
+	return func(t *table[K, V]) {
+		t.lazyInit = true
+	}
+}
+
+// WithStrictValueSize rejects value types larger than 64 bytes, a cache line.
+// Values are stored inline in the groups, so large ones spread every group over
+// many cache lines and slow down every lookup, a pointer to the value should be
+// stored instead. TryNew returns an error wrapping ErrValueTooLarge for such a type,
+// and New panics with it. It's only checked when the map is created.
+func WithStrictValueSize[K comparable, V any]() Option[K, V] {
+	return func(t *table[K, V]) {
+		t.strictValueSize = true
+	}
+}
+
+// WithInitialData fills the map with the given entries right after it's allocated,
+// keys[i] being set to values[i]. Later entries overwrite earlier ones with the same key.
+// `values` may be nil to set zero values, e.g. for NewSet.
+// TryNew returns ErrLengthMismatch if the slices' lengths differ, or an error wrapping
+// ErrTableFull if the entries don't fit into the requested capacity, New panics with it.
+// It's meant for New and NewSet, not for maps built out of several tables
+// like ShardedMap, which would load the data into each of them.
+func WithInitialData[K comparable, V any](keys []K, values []V) Option[K, V] {
+	return func(t *table[K, V]) {
+		t.afterInit = func(t *table[K, V]) error {
+			if values != nil && len(keys) != len(values) {
+				return ErrLengthMismatch
+			}
+
+			for i, key := range keys {
+				var value V
+				if values != nil {
+					value = values[i]
+				}
+
+				if err := t.set(key, value); err != nil {
+					return fmt.Errorf("loading initial data: %w", err)
+				}
+			}
+
+			return nil
+		}
+	}
+}
+
 func (t *table[K, V]) init(capacity int, opts ...Option[K, V]) {
-	capacity = max(capacity, groupSize)
-	normalizedCapacity := uintptr(NextPowerOf2(uint32(capacity)))
+	t.configure(opts...)
+
+	if err := t.checkValueSize(); err != nil {
+		panic(err)
+	}
+
+	if err := t.create(capacity); err != nil {
+		panic(err)
+	}
+}
+
+// configure sets the defaults, then applies the options.
+func (t *table[K, V]) configure(opts ...Option[K, V]) {
+	t.compactionThresholdFactor = defaultCompactionThresholdFactor
+	t.probeAccel = 1
+
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	if t.hashFunc == nil {
+		t.hashFunc = MakeDefaultHashFunc[K](maphash.MakeSeed())
+	}
+}
+
+// checkValueSize enforces WithStrictValueSize.
+func (t *table[K, V]) checkValueSize() error {
+	if size := unsafe.Sizeof(t.emptyV); t.strictValueSize && size > maxValueSize {
+		return fmt.Errorf("%w: %T takes %d bytes, store a pointer to it instead", ErrValueTooLarge, t.emptyV, size)
+	}
+
+	return nil
+}
+
+// create allocates the configured table, then loads its initial data, if any.
+func (t *table[K, V]) create(capacity int) error {
+	t.alloc(capacity)
+
+	if t.afterInit != nil {
+		afterInit := t.afterInit
+		t.afterInit = nil
+
+		return afterInit(t)
+	}
+
+	return nil
+}
+
+// options returns the options reproducing the table's configuration,
+// for building another table that behaves the same.
+func (t *table[K, V]) options() []Option[K, V] {
+	return optionsOf[K, V, V](t)
+}
+
+// optionsOf is table.options for a table with another value type.
+func optionsOf[K comparable, V, W any](t *table[K, V]) []Option[K, W] {
+	return []Option[K, W]{
+		func(nt *table[K, W]) {
+			nt.hashFunc = t.hashFunc
+			nt.compactionThresholdFactor = t.compactionThresholdFactor
+			nt.compactionThresholdRatio = t.compactionThresholdRatio
+			nt.loadFactor = t.loadFactor
+			nt.probeAccel = t.probeAccel
+			nt.strideMask = t.strideMask
+			nt.cacheHashes = t.cacheHashes
+			nt.backshiftDelete = t.backshiftDelete
+			nt.maxProbe = t.maxProbe
+			nt.autoTrimLoadFactor = t.autoTrimLoadFactor
+			nt.compactionPolicyEvery = t.compactionPolicyEvery
+			nt.compactionPolicy = t.compactionPolicy
+		},
+	}
+}
+
+// alloc allocates empty groups for the given capacity and derives the
+// capacity-dependent limits. Any previous content is dropped.
+func (t *table[K, V]) alloc(capacity int) {
+	normalizedCapacity := normalizeCapacity(capacity)
 	// Number of groups required
 	numGroups := normalizedCapacity / groupSize
 	numGroupsMask := uintptr(numGroups - 1)
 
 	t.groups = make([]group[K, V], numGroups)
+	if t.cacheHashes {
+		t.hashes = make([]uint64, normalizedCapacity)
+	}
 	t.capacity = normalizedCapacity
 	t.numGroupsMask = numGroupsMask
-	t.capacityEffective = normalizedCapacity * 7 / 8
-	t.compactionThresholdFactor = defaultCompactionThresholdFactor
+	t.probeLimit = numGroupsMask
+	if t.maxProbe > 0 {
+		t.probeLimit = min(numGroupsMask, t.maxProbe-1)
+	}
+	t.capacityEffective = t.effectiveCapacity(normalizedCapacity)
+	t.tombstoneCompactionThreshold = t.compactionThreshold()
 
-	// Initialize all control bytes to Empty
-	t.Reset()
+	// Zeroed control bytes are already empty, writing them only faults the pages in
+	if !t.lazyInit {
+		for i := range t.groups {
+			copy(t.groups[i].ctrls[:], emptyCtrls[:])
+		}
+	}
+	t.resetCounters()
+}
 
-	for _, opt := range opts {
-		opt(t)
+// effectiveCapacity returns how many entries fit into the given number of slots
+// under the table's load factor.
+func (t *table[K, V]) effectiveCapacity(capacity uintptr) uintptr {
+	if t.loadFactor > 0 {
+		return uintptr(float64(capacity) * t.loadFactor)
 	}
 
-	// Calculate threshold after options are applied
-	t.tombstoneCompactionThreshold = t.capacityEffective / t.compactionThresholdFactor
+	return capacity * 7 / 8
+}
 
-	if t.hashFunc == nil {
-		t.hashFunc = MakeDefaultHashFunc[K](maphash.MakeSeed())
+// compactionThreshold returns the number of tombstones that triggers compaction.
+func (t *table[K, V]) compactionThreshold() uintptr {
+	if t.compactionThresholdRatio > 0 {
+		// The smallest count for which tombstones/capacityEffective exceeds the ratio
+		return uintptr(float32(t.capacityEffective)*t.compactionThresholdRatio) + 1
 	}
+
+	return t.capacityEffective / t.compactionThresholdFactor
 }
 
 func (t *table[K, V]) Stats() Stats {
@@ -112,243 +474,1256 @@ func (t *table[K, V]) Stats() Stats {
 
 	return Stats{
 		Size:                    int(t.size),
+		Capacity:                int(t.capacity),
 		EffectiveCapacity:       int(t.capacityEffective),
 		Tombstones:              int(t.tombstones),
 		TombstonesCapacityRatio: tombstonesCapacityRatio,
 		TombstonesSizeRatio:     tombstonesSizeRatio,
+		PeakProbeLength:         int(t.peakProbeLength),
 	}
 }
 
-// needsCompaction returns true if the table has accumulated enough tombstones
-// to warrant compaction. The threshold is when tombstones reach at least
-// effectiveCapacity/factor, where factor defaults to 3 and can be configured
-// via WithCompactionThresholdFactor.
-func (t *table[K, V]) needsCompaction() bool {
-	return t.tombstones >= t.tombstoneCompactionThreshold
-}
+// Histogram returns the distribution of live entries across groups:
+// the value at index i is the number of groups holding exactly i live entries.
+// A well-distributed table has most groups near Size/groups, a skewed one
+// points at hash clustering.
+func (t *table[K, V]) Histogram() []int {
+	t.completeCompaction()
 
-func (t *table[K, V]) get(key K) (V, bool) {
-	h1, h2 := HashSplit(t.hashFunc(key))
-	mask := t.numGroupsMask
-	start := (h1 / groupSize) & mask
+	histogram := make([]int, groupSize+1)
 
-	for p, offset := uintptr(0), start; p <= mask; p++ {
-		g := &t.groups[offset]
-		ctrl := *(*uint64)(unsafe.Pointer(&g.ctrls))
+	for i := range t.groups {
+		ctrl := *(*uint64)(unsafe.Pointer(&t.groups[i].ctrls))
+		histogram[bits.OnesCount64(uint64(matchFull(ctrl)))]++
+	}
 
-		// SIMD-like match
-		if matches := matchH2(ctrl, h2); matches != 0 {
-			for matches != 0 {
-				idx := matches.first()
-				if g.slots[idx] == key {
-					return g.values[idx], true
-				}
+	return histogram
+}
 
-				matches = matches.removeFirst()
-			}
-		}
+// ShouldCompact reports whether the share of tombstones in the effective capacity,
+// Stats().TombstonesCapacityRatio, exceeds `threshold`.
+func (t *table[K, V]) ShouldCompact(threshold float32) bool {
+	return t.Stats().TombstonesCapacityRatio > threshold
+}
 
-		// Termination
-		if matchEmpty(ctrl) != 0 {
-			return t.emptyV, false
-		}
+// TombstoneBytes returns the storage held by tombstones: the control byte, key,
+// value and, with WithCachedHashes, cached hash of every deleted slot. Compaction
+// makes it available to new entries again, but the allocation doesn't shrink, see Trim.
+// Memory referenced by the stale keys and values, e.g. string contents, isn't counted.
+func (t *table[K, V]) TombstoneBytes() uintptr {
+	var g group[K, V]
 
-		// Quadratic probe math
-		offset = (start + (p+1)*(p+2)/2) & mask
+	slotBytes := unsafe.Sizeof(g.ctrls[0]) + unsafe.Sizeof(g.slots[0]) + unsafe.Sizeof(g.values[0])
+	if t.hashes != nil {
+		slotBytes += unsafe.Sizeof(t.hashes[0])
 	}
 
-	return t.emptyV, false
+	return t.tombstones * slotBytes
 }
 
-func (t *table[K, V]) set(key K, value V) error {
-	var (
-		h1, h2 = HashSplit(t.hashFunc(key))
-		mask   = t.numGroupsMask
-		start  = (h1 / groupSize) & mask
+// CompactionBenefit estimates the fraction of the total probe length that tombstones
+// are responsible for, between 0 and 1. For every live entry, it compares the number
+// of groups visited to find it with the number it'd take if the entry were moved to
+// the first group with a tombstone along its probe sequence, as a compaction would do.
+// 0 means that compacting wouldn't shorten any probe.
+// It walks the whole table, and hashes every key unless WithCachedHashes is set.
+func (t *table[K, V]) CompactionBenefit() float32 {
+	t.completeCompaction()
 
-		targetGroup *group[K, V]
-		targetSlot  uintptr
-		foundSlot   bool
-	)
+	var total, saved uintptr
 
-	for p, offset := uintptr(0), start; p <= mask; p++ {
-		g := &t.groups[offset]
+	for i := range t.groups {
+		g := &t.groups[i]
 		ctrl := *(*uint64)(unsafe.Pointer(&g.ctrls))
 
-		// 1. Existing check - updates are always allowed, even at capacity
-		matchMask := matchH2(ctrl, h2)
-		for matchMask != 0 {
-			idx := matchMask.first()
-			if g.slots[idx] == key {
-				g.values[idx] = value
-				return nil
+		for matches := matchFull(ctrl); matches != 0; matches = matches.removeFirst() {
+			idx := matches.first()
+
+			var hash uint64
+			if t.hashes != nil {
+				hash = t.hashes[uintptr(i)*groupSize+idx]
+			} else {
+				hash = t.hashFunc(g.slots[idx])
 			}
 
-			matchMask = matchMask.removeFirst()
-		}
+			h1, _ := HashSplit(hash)
+			seq := t.probe(h1)
 
-		// 2. Cache first available slot
-		if !foundSlot {
-			matchMask = matchEmptyOrDeleted(ctrl)
-			if matchMask != 0 {
-				targetGroup = g
-				targetSlot = matchMask.first()
-				foundSlot = true
+			var free uintptr
+			for p := uintptr(1); p <= t.numGroupsMask+1; p++ {
+				if free == 0 && matchEmptyOrDeleted(*(*uint64)(unsafe.Pointer(&t.groups[seq.offset].ctrls))) != 0 {
+					free = p
+				}
+
+				if seq.offset == uintptr(i) {
+					total += p
+					if free > 0 {
+						saved += p - free
+					}
+					break
+				}
+
+				seq.next()
 			}
 		}
+	}
 
-		// 3. Termination condition
-		matchMask = matchEmpty(ctrl)
-		if matchMask != 0 {
-			break
+	if total == 0 {
+		return 0
+	}
+
+	return float32(saved) / float32(total)
+}
+
+// CheckInvariants verifies the internal consistency of the table and returns
+// an error describing the first violation found. It walks the whole table
+// and is meant for tests, fuzzing and debugging.
+//
+// Checked invariants:
+//   - every control byte is either a 7-bit h2 with the MSB set, slotEmpty or slotDeleted;
+//   - cached hashes, if enabled, match the keys;
+//   - size matches the number of full slots, and tombstones the number of deleted ones;
+//   - every live key is found by a lookup from its home group, in its own slot.
+func (t *table[K, V]) CheckInvariants() error {
+	t.completeCompaction()
+
+	var full, deleted uintptr
+
+	for i := range t.groups {
+		g := &t.groups[i]
+
+		for j := range uintptr(groupSize) {
+			ctrl := g.ctrls[j]
+
+			switch {
+			case ctrl == slotEmpty:
+			case ctrl == slotDeleted:
+				deleted++
+			case ctrl&slotFull == 0:
+				return fmt.Errorf("group %d slot %d: invalid control byte 0x%02X", i, j, ctrl)
+			default:
+				full++
+
+				hash := t.hashFunc(g.slots[j])
+				if t.hashes != nil && t.hashes[uintptr(i)*groupSize+j] != hash {
+					return fmt.Errorf("group %d slot %d: cached hash doesn't match key %v", i, j, g.slots[j])
+				}
+				if _, h2 := HashSplit(hash); h2|slotFull != ctrl {
+					return fmt.Errorf("group %d slot %d: control byte 0x%02X doesn't match key h2 0x%02X", i, j, ctrl, h2)
+				}
+
+				fg, idx, ok := t.find(g.slots[j], hash)
+				if !ok {
+					return fmt.Errorf("group %d slot %d: key %v is unreachable from its home group", i, j, g.slots[j])
+				}
+				if fg != g || idx != j {
+					return fmt.Errorf("group %d slot %d: key %v is shadowed by a duplicate", i, j, g.slots[j])
+				}
+			}
 		}
+	}
 
-		offset = (start + (p+1)*(p+2)/2) & mask
+	if full != t.size {
+		return fmt.Errorf("size is %d, but %d slots are full", t.size, full)
 	}
 
-	// Inserting a new key - check capacity
-	if t.size >= t.capacityEffective {
-		return ErrTableFull
+	if deleted != t.tombstones {
+		return fmt.Errorf("tombstones is %d, but %d slots are deleted", t.tombstones, deleted)
 	}
 
-	if foundSlot {
-		if targetGroup.ctrls[targetSlot] == slotDeleted {
-			t.tombstones--
-		}
+	return nil
+}
 
-		targetGroup.ctrls[targetSlot] = h2
-		targetGroup.slots[targetSlot] = key
-		targetGroup.values[targetSlot] = value
-		t.size++
+// WalkSlots calls fn for every slot of the table in memory order, including empty
+// and deleted ones, e.g. for serialization of the raw layout and diagnostics.
+// ctrl is the slot's control byte: 0x00 for an empty slot, 0x7E for a deleted one,
+// and the h2 of the key (0x00-0x7F) with the MSB set (0x80) for a live one.
+// The key and value of a slot that isn't live are stale or zero, and meaningless.
+// fn must not modify the table.
+func (t *table[K, V]) WalkSlots(fn func(groupIdx int, slotIdx int, ctrl uint8, key K, value V)) {
+	t.completeCompaction()
 
-		return nil
+	for i := range t.groups {
+		g := &t.groups[i]
+		for j := range groupSize {
+			fn(i, j, g.ctrls[j], g.slots[j], g.values[j])
+		}
 	}
-
-	return ErrTableFull
 }
 
-func (t *table[K, V]) delete(key K) bool {
-	h1, h2 := HashSplit(t.hashFunc(key))
-	mask := t.numGroupsMask
-	start := (h1 / groupSize) & mask
+// ProbePath returns the indices of the groups a lookup of the key visits, in order,
+// until it finds the key or a group with an empty slot. It's meant for debugging
+// slow keys, together with Dump.
+func (t *table[K, V]) ProbePath(key K) []uintptr {
+	t.completeCompaction()
 
-	for p, offset := uintptr(0), start; p <= mask; p++ {
-		g := &t.groups[offset]
-		ctrl := *(*uint64)(unsafe.Pointer(&g.ctrls))
+	var path []uintptr
 
-		// 1. Check current group for the key
-		matchMask := matchH2(ctrl, h2)
-		for matchMask != 0 {
-			idx := matchMask.first()
-			if g.slots[idx] == key {
-				// Mark as Deleted (0xFE) to preserve the probe chain
-				g.ctrls[idx] = slotDeleted
-				t.size--
-				t.tombstones++
+	h1, h2 := HashSplit(t.hashFunc(key))
+	limit := t.probeLimit
+	seq := t.probe(h1)
 
-				if t.needsCompaction() {
-					t.compact()
-				}
+	for p := uintptr(0); p <= limit; p++ {
+		g := &t.groups[seq.offset]
+		ctrl := *(*uint64)(unsafe.Pointer(&g.ctrls))
+		path = append(path, seq.offset)
 
-				return true
+		for matches := matchH2(ctrl, h2); matches != 0; matches = matches.removeFirst() {
+			if g.slots[matches.first()] == key {
+				return path
 			}
-
-			matchMask = matchMask.removeFirst()
 		}
 
 		if matchEmpty(ctrl) != 0 {
-			return false
+			return path
 		}
 
-		offset = (start + (p+1)*(p+2)/2) & mask
+		seq.next()
 	}
 
-	return false
+	return path
 }
 
-func (t *table[K, V]) Reset() {
-	for i := range t.groups {
-		copy(t.groups[i].ctrls[:], emptyCtrls[:])
-	}
+// SameHomeGroup reports whether keys a and b start their probe sequences at the same
+// group of this table, e.g. to measure the quality of a hash function over a sample of
+// real keys. The home group depends on the number of groups, so keys sharing it may be
+// split apart by a larger table. Keys with the same h2 as well are full collisions,
+// which only the key comparison tells apart.
+func (t *table[K, V]) SameHomeGroup(a, b K) bool {
+	h1a, _ := HashSplit(t.hashFunc(a))
+	h1b, _ := HashSplit(t.hashFunc(b))
 
-	t.size = 0
-	t.tombstones = 0
+	return t.probe(h1a).offset == t.probe(h1b).offset
 }
 
-func (t *table[K, V]) compact() {
-	// We want to drop all of the deletes in place. We first walk over the
-	// control bytes and mark every DELETED slot as EMPTY and every FULL slot
-	// as DELETED. Marking the DELETED slots as EMPTY has effectively dropped
-	// the tombstones, but we fouled up the probe invariant. Marking the FULL
-	// slots as DELETED gives us a marker to locate the previously FULL slots.
+// Dump renders the table layout for debugging, one group per line.
+// Each slot is shown as E (empty), D (deleted), or its h2 in hex followed by
+// the stored key, e.g.:
+//
+//	0: 3A=foo E D E E E E E
+func (t *table[K, V]) Dump() string {
+	t.completeCompaction()
+
+	var b strings.Builder
 
-	// Mark all DELETED slots as EMPTY and all FULL slots as DELETED.
 	for i := range t.groups {
 		g := &t.groups[i]
-		ctrl := *(*uint64)(unsafe.Pointer(&g.ctrls))
-		*(*uint64)(unsafe.Pointer(&g.ctrls)) = invertCtrls(ctrl)
-	}
-
-	for idx := 0; idx < len(t.groups); idx++ {
-		g := &t.groups[idx]
-		for j := uintptr(0); j < groupSize; j++ {
-			// Only process slots we marked as Deleted (which were originally Full)
-			if g.ctrls[j] != slotDeleted {
-				continue
-			}
 
-			var (
-				key          = g.slots[j]
-				value        = g.values[j]
-				h            = t.hashFunc(key)
-				h1, h2       = HashSplit(h)
-				destGroupIdx = (h1 / groupSize) & t.numGroupsMask
-
-				targetGroup *group[K, V]
-				targetSlot  uintptr
-
-				p        = uintptr(0)
-				currGIdx = destGroupIdx
-			)
-
-			for {
-				tg := &t.groups[currGIdx]
-				tc := *(*uint64)(unsafe.Pointer(&tg.ctrls))
-				m := matchEmptyOrDeleted(tc)
-				if m != 0 {
-					targetGroup = tg
-					targetSlot = m.first()
-					break
-				}
-				p++
-				currGIdx = (currGIdx + p) & t.numGroupsMask
+		fmt.Fprintf(&b, "%d:", i)
+		for j := range groupSize {
+			switch ctrl := g.ctrls[j]; ctrl {
+			case slotEmpty:
+				b.WriteString(" E")
+			case slotDeleted:
+				b.WriteString(" D")
+			default:
+				fmt.Fprintf(&b, " %02X=%v", ctrl&^slotFull, g.slots[j])
 			}
+		}
+		b.WriteByte('\n')
+	}
 
-			// Swap / Move logic
-			// Swapping within the same group
-			if targetGroup == g && targetSlot == j {
-				g.ctrls[j] = h2
-			} else if targetGroup.ctrls[targetSlot] == slotEmpty {
-				// Target group slot is empty
-				targetGroup.ctrls[targetSlot] = h2
-				targetGroup.slots[targetSlot] = key
-				targetGroup.values[targetSlot] = value
-				g.ctrls[j] = slotEmpty
-			} else {
-				// SWAP: targetG.ctrls[targetSlot] is slotDeleted
-				targetGroup.ctrls[targetSlot] = h2
+	return b.String()
+}
 
-				// SWAP: Swapping values and keys as well
-				g.slots[j], targetGroup.slots[targetSlot] = targetGroup.slots[targetSlot], g.slots[j]
-				g.values[j], targetGroup.values[targetSlot] = targetGroup.values[targetSlot], g.values[j]
+// probeSeq is the sequence of groups visited for a key, starting at its home group.
+// The distance to the next group grows by accel at every step: with accel=1 it's
+// the quadratic (triangular) sequence start, start+1, start+3, start+6, ...,
+// with accel=0 it's linear. Both visit every group within numGroups steps,
+// since the number of groups is a power of 2.
+type probeSeq struct {
+	mask   uintptr
+	offset uintptr
+	stride uintptr
+	accel  uintptr
+}
 
-				// Decrement to re-process this slot with the swapped-in key.
-				// When j=0, underflow to MaxUintptr is intentional: the loop's
-				// j++ wraps it back to 0, correctly re-processing slot 0.
-				j--
-			}
-		}
+// probe returns the probe sequence for a key with the given h1.
+func (t *table[K, V]) probe(h1 uintptr) probeSeq {
+	return probeSeq{
+		mask:   t.numGroupsMask,
+		offset: (h1 / groupSize) & t.numGroupsMask,
+		// 1 unless double hashing is on: the home group comes from the low bits of h1,
+		// the stride from the high ones.
+		stride: (h1>>(bits.UintSize/2))&t.strideMask | 1,
+		accel:  t.probeAccel,
 	}
+}
 
-	t.tombstones = 0
+func (s *probeSeq) next() {
+	s.offset = (s.offset + s.stride) & s.mask
+	s.stride += s.accel
+}
+
+// needsCompaction returns true if the table has accumulated enough tombstones
+// to warrant compaction. The threshold is when tombstones reach at least
+// effectiveCapacity/factor, where factor defaults to 3 and can be configured
+// via WithCompactionThresholdFactor, or when they exceed the ratio set via WithAutoCompact.
+func (t *table[K, V]) needsCompaction() bool {
+	return t.tombstones >= t.tombstoneCompactionThreshold
+}
+
+func (t *table[K, V]) get(key K) (V, bool) {
+	return t.getHashed(key, t.hashFunc(key))
+}
+
+// getHashed is get with the key's hash already computed by the caller.
+func (t *table[K, V]) getHashed(key K, hash uint64) (V, bool) {
+	if t.compacting {
+		if g, idx, _, ok := t.findCompacting(key, hash); ok {
+			return g.values[idx], true
+		}
+
+		return t.emptyV, false
+	}
+
+	h1, h2 := HashSplit(hash)
+	limit := t.probeLimit
+	seq := t.probe(h1)
+
+	for p := uintptr(0); p <= limit; p++ {
+		g := &t.groups[seq.offset]
+		ctrl := *(*uint64)(unsafe.Pointer(&g.ctrls))
+
+		// SIMD-like match
+		if matches := matchH2(ctrl, h2); matches != 0 {
+			for matches != 0 {
+				idx := matches.first()
+				if g.slots[idx] == key {
+					return g.values[idx], true
+				}
+
+				matches = matches.removeFirst()
+			}
+		}
+
+		// Termination
+		if matchEmpty(ctrl) != 0 {
+			return t.emptyV, false
+		}
+
+		seq.next()
+	}
+
+	return t.emptyV, false
+}
+
+// getWithProbes is get, also returning the number of groups visited.
+// It's kept apart from getHashed, so that counting costs nothing to plain lookups.
+func (t *table[K, V]) getWithProbes(key K, hash uint64) (V, bool, int) {
+	if t.compacting {
+		g, idx, probes, ok := t.findCompacting(key, hash)
+		if !ok {
+			return t.emptyV, false, probes
+		}
+
+		return g.values[idx], true, probes
+	}
+
+	h1, h2 := HashSplit(hash)
+	limit := t.probeLimit
+	seq := t.probe(h1)
+
+	for p := uintptr(0); p <= limit; p++ {
+		g := &t.groups[seq.offset]
+		ctrl := *(*uint64)(unsafe.Pointer(&g.ctrls))
+
+		for matches := matchH2(ctrl, h2); matches != 0; matches = matches.removeFirst() {
+			idx := matches.first()
+			if g.slots[idx] == key {
+				return g.values[idx], true, int(p) + 1
+			}
+		}
+
+		if matchEmpty(ctrl) != 0 {
+			return t.emptyV, false, int(p) + 1
+		}
+
+		seq.next()
+	}
+
+	return t.emptyV, false, int(limit) + 1
+}
+
+// find returns the group and the slot index holding the key.
+func (t *table[K, V]) find(key K, hash uint64) (*group[K, V], uintptr, bool) {
+	if t.compacting {
+		g, idx, _, ok := t.findCompacting(key, hash)
+		return g, idx, ok
+	}
+
+	h1, h2 := HashSplit(hash)
+	limit := t.probeLimit
+	seq := t.probe(h1)
+
+	for p := uintptr(0); p <= limit; p++ {
+		g := &t.groups[seq.offset]
+		ctrl := *(*uint64)(unsafe.Pointer(&g.ctrls))
+
+		for matches := matchH2(ctrl, h2); matches != 0; matches = matches.removeFirst() {
+			idx := matches.first()
+			if g.slots[idx] == key {
+				return g, idx, true
+			}
+		}
+
+		if matchEmpty(ctrl) != 0 {
+			return nil, 0, false
+		}
+
+		seq.next()
+	}
+
+	return nil, 0, false
+}
+
+// findCompacting is find for a table with a pending compaction, also returning the
+// number of groups visited. The entries not relocated yet lost their h2, so every
+// pending slot along the probe sequence is compared by key.
+func (t *table[K, V]) findCompacting(key K, hash uint64) (*group[K, V], uintptr, int, bool) {
+	h1, h2 := HashSplit(hash)
+	limit := t.probeLimit
+	seq := t.probe(h1)
+
+	for p := uintptr(0); p <= limit; p++ {
+		g := &t.groups[seq.offset]
+		ctrl := *(*uint64)(unsafe.Pointer(&g.ctrls))
+
+		for matches := matchH2(ctrl, h2) | matchPending(ctrl); matches != 0; matches = matches.removeFirst() {
+			idx := matches.first()
+			if g.slots[idx] == key {
+				return g, idx, int(p) + 1, true
+			}
+		}
+
+		// Relocating never empties a slot, the probe sequence is unbroken
+		if matchEmpty(ctrl) != 0 {
+			return nil, 0, int(p) + 1, false
+		}
+
+		seq.next()
+	}
+
+	return nil, 0, int(limit) + 1, false
+}
+
+// getMany looks up every key, storing the results at the same index of values and found.
+// Lookups are pipelined: the home control word of keys[i+1] is loaded before the probe
+// for keys[i] runs, so the CPU can overlap that cache miss with the current lookup.
+// Go has no portable prefetch intrinsic, and an early load that's actually consumed
+// is the closest equivalent that the compiler won't eliminate.
+func (t *table[K, V]) getMany(keys []K, values []V, found []bool) {
+	if len(keys) == 0 {
+		return
+	}
+
+	if t.compacting {
+		for i, key := range keys {
+			values[i], found[i] = t.get(key)
+		}
+
+		return
+	}
+
+	var (
+		h1, h2 = HashSplit(t.hashFunc(keys[0]))
+		seq    = t.probe(h1)
+		ctrl   = *(*uint64)(unsafe.Pointer(&t.groups[seq.offset].ctrls))
+	)
+
+	for i, key := range keys {
+		var (
+			nextSeq  probeSeq
+			nextCtrl uint64
+			nextH2   uint8
+		)
+
+		if i+1 < len(keys) {
+			var nextH1 uintptr
+			nextH1, nextH2 = HashSplit(t.hashFunc(keys[i+1]))
+			nextSeq = t.probe(nextH1)
+			nextCtrl = *(*uint64)(unsafe.Pointer(&t.groups[nextSeq.offset].ctrls))
+		}
+
+		values[i], found[i] = t.getFrom(key, h2, seq, ctrl)
+
+		h2, seq, ctrl = nextH2, nextSeq, nextCtrl
+	}
+}
+
+// getFrom is the probe loop of get, with the home group's control word already loaded.
+func (t *table[K, V]) getFrom(key K, h2 uint8, seq probeSeq, ctrl uint64) (V, bool) {
+	limit := t.probeLimit
+
+	for p := uintptr(0); p <= limit; p++ {
+		g := &t.groups[seq.offset]
+		if p > 0 {
+			ctrl = *(*uint64)(unsafe.Pointer(&g.ctrls))
+		}
+
+		for matches := matchH2(ctrl, h2); matches != 0; matches = matches.removeFirst() {
+			idx := matches.first()
+			if g.slots[idx] == key {
+				return g.values[idx], true
+			}
+		}
+
+		if matchEmpty(ctrl) != 0 {
+			return t.emptyV, false
+		}
+
+		seq.next()
+	}
+
+	return t.emptyV, false
+}
+
+func (t *table[K, V]) set(key K, value V) error {
+	return t.setHashed(key, t.hashFunc(key), value)
+}
+
+// setHashed is set with the key's hash already computed by the caller.
+func (t *table[K, V]) setHashed(key K, hash uint64, value V) error {
+	t.completeCompaction()
+
+	return t.insert(key, hash, value, true)
+}
+
+// setMany sets keys[i] to values[i], or to the zero value if values is nil,
+// stopping at the first key that doesn't fit. Returns the number of keys set.
+// A pending compaction is completed once for the whole batch, and so is the
+// capacity check if every key fits, even if none of them is present yet.
+func (t *table[K, V]) setMany(keys []K, values []V) (int, error) {
+	t.completeCompaction()
+
+	checkCapacity := t.size+uintptr(len(keys)) > t.capacityEffective
+
+	for i, key := range keys {
+		var value V
+		if values != nil {
+			value = values[i]
+		}
+
+		if err := t.insert(key, t.hashFunc(key), value, checkCapacity); err != nil {
+			return i, err
+		}
+	}
+
+	return len(keys), nil
+}
+
+// insert sets the key in a table with no pending compaction.
+// checkCapacity may only be false if the caller made sure that a new key fits
+// under the load factor.
+func (t *table[K, V]) insert(key K, hash uint64, value V, checkCapacity bool) error {
+	var (
+		h1, h2 = HashSplit(hash)
+		limit  = t.probeLimit
+		seq    = t.probe(h1)
+
+		targetGroup    *group[K, V]
+		targetGroupIdx uintptr
+		targetSlot     uintptr
+		targetProbe    uintptr
+		foundSlot      bool
+	)
+
+	for p := uintptr(0); p <= limit; p++ {
+		g := &t.groups[seq.offset]
+		ctrl := *(*uint64)(unsafe.Pointer(&g.ctrls))
+
+		// 1. Existing check - updates are always allowed, even at capacity
+		matchMask := matchH2(ctrl, h2)
+		for matchMask != 0 {
+			idx := matchMask.first()
+			if g.slots[idx] == key {
+				g.values[idx] = value
+				t.mutated(1)
+
+				return nil
+			}
+
+			matchMask = matchMask.removeFirst()
+		}
+
+		// 2. Cache first available slot
+		if !foundSlot {
+			matchMask = matchEmptyOrDeleted(ctrl)
+			if matchMask != 0 {
+				targetGroup = g
+				targetGroupIdx = seq.offset
+				targetSlot = matchMask.first()
+				targetProbe = p + 1
+				foundSlot = true
+			}
+		}
+
+		// 3. Termination condition
+		matchMask = matchEmpty(ctrl)
+		if matchMask != 0 {
+			break
+		}
+
+		seq.next()
+	}
+
+	// Inserting a new key - check capacity
+	if checkCapacity && t.size >= t.capacityEffective {
+		return ErrTableFull
+	}
+
+	if foundSlot {
+		if targetGroup.ctrls[targetSlot] == slotDeleted {
+			t.tombstones--
+		}
+
+		targetGroup.ctrls[targetSlot] = h2 | slotFull
+		targetGroup.slots[targetSlot] = key
+		targetGroup.values[targetSlot] = value
+		if t.hashes != nil {
+			t.hashes[targetGroupIdx*groupSize+targetSlot] = hash
+		}
+		t.size++
+		t.lowLoadDeletes = 0
+		t.peakProbeLength = max(t.peakProbeLength, targetProbe)
+		t.mutated(1)
+
+		return nil
+	}
+
+	return ErrTableFull
+}
+
+func (t *table[K, V]) delete(key K) bool {
+	return t.deleteHashed(key, t.hashFunc(key))
+}
+
+// deleteHashed is delete with the key's hash already computed by the caller.
+func (t *table[K, V]) deleteHashed(key K, hash uint64) bool {
+	t.completeCompaction()
+
+	return t.remove(key, hash)
+}
+
+// deleteMany deletes the given keys and returns the number of deleted ones.
+// A pending compaction is completed once for the whole batch.
+func (t *table[K, V]) deleteMany(keys []K) int {
+	t.completeCompaction()
+
+	var deleted int
+	for _, key := range keys {
+		if t.remove(key, t.hashFunc(key)) {
+			deleted++
+		}
+	}
+
+	return deleted
+}
+
+// remove deletes the key from a table with no pending compaction.
+func (t *table[K, V]) remove(key K, hash uint64) bool {
+	h1, h2 := HashSplit(hash)
+	limit := t.probeLimit
+	seq := t.probe(h1)
+
+	for p := uintptr(0); p <= limit; p++ {
+		g := &t.groups[seq.offset]
+		ctrl := *(*uint64)(unsafe.Pointer(&g.ctrls))
+
+		// 1. Check current group for the key
+		matchMask := matchH2(ctrl, h2)
+		for matchMask != 0 {
+			idx := matchMask.first()
+			if g.slots[idx] == key {
+				t.deleteAt(g, idx)
+				return true
+			}
+
+			matchMask = matchMask.removeFirst()
+		}
+
+		if matchEmpty(ctrl) != 0 {
+			return false
+		}
+
+		seq.next()
+	}
+
+	return false
+}
+
+// deleteAt deletes the live entry at the given slot.
+func (t *table[K, V]) deleteAt(g *group[K, V], idx uintptr) {
+	if t.backshiftDelete {
+		t.backshift(t.groupIndex(g), idx)
+		t.size--
+		t.mutated(1)
+		t.autoTrim(1)
+
+		return
+	}
+
+	// Mark as Deleted (0x7E) to preserve the probe chain
+	g.ctrls[idx] = slotDeleted
+	t.removed(1)
+}
+
+// groupIndex returns the index of the given group in t.groups.
+func (t *table[K, V]) groupIndex(g *group[K, V]) uintptr {
+	return (uintptr(unsafe.Pointer(g)) - uintptr(unsafe.Pointer(&t.groups[0]))) / unsafe.Sizeof(*g)
+}
+
+// backshift frees the slot `idx` of group `hole`, see WithBackshiftDelete.
+// With linear probing, an entry's probe chain passes through a group if the group is
+// between the entry's home group and the entry's own. The groups following the hole
+// are scanned for such an entry, which is moved into the hole, leaving a new hole
+// behind it, until the scan reaches a group with an empty slot: no probe chain goes
+// past it, so the last hole can be marked empty.
+func (t *table[K, V]) backshift(hole, idx uintptr) {
+	mask := t.numGroupsMask
+
+	for matchEmpty(*(*uint64)(unsafe.Pointer(&t.groups[hole].ctrls))) == 0 {
+		moved := false
+
+		for i := (hole + 1) & mask; i != hole && !moved; i = (i + 1) & mask {
+			g := &t.groups[i]
+			ctrl := *(*uint64)(unsafe.Pointer(&g.ctrls))
+
+			for matches := matchFull(ctrl); matches != 0; matches = matches.removeFirst() {
+				slot := matches.first()
+
+				var hash uint64
+				if t.hashes != nil {
+					hash = t.hashes[i*groupSize+slot]
+				} else {
+					hash = t.hashFunc(g.slots[slot])
+				}
+
+				h1, _ := HashSplit(hash)
+				home := (h1 / groupSize) & mask
+
+				// The entry's chain passes through the hole if the hole is no further
+				// from the entry than the entry's home group is.
+				if (i-home)&mask < (i-hole)&mask {
+					continue
+				}
+
+				dst := &t.groups[hole]
+				dst.ctrls[idx] = g.ctrls[slot]
+				dst.slots[idx] = g.slots[slot]
+				dst.values[idx] = g.values[slot]
+				if t.hashes != nil {
+					t.hashes[hole*groupSize+idx] = hash
+				}
+
+				hole, idx, moved = i, slot, true
+
+				break
+			}
+
+			if matchEmpty(ctrl) != 0 {
+				break
+			}
+		}
+
+		if !moved {
+			break
+		}
+	}
+
+	t.groups[hole].ctrls[idx] = slotEmpty
+}
+
+// removed accounts for `n` entries just marked as deleted, then compacts the table
+// if the tombstone threshold or the compaction policy asks for it.
+func (t *table[K, V]) removed(n uintptr) {
+	t.size -= n
+	t.tombstones += n
+
+	if t.needsCompaction() {
+		t.compact()
+	}
+
+	t.mutated(n)
+	t.autoTrim(n)
+}
+
+// autoTrim counts `n` deletes towards WithAutoTrim, and trims the table once
+// the load has stayed below the threshold for long enough.
+func (t *table[K, V]) autoTrim(n uintptr) {
+	if t.autoTrimLoadFactor == 0 {
+		return
+	}
+
+	if float32(t.size) >= t.autoTrimLoadFactor*float32(t.capacity) {
+		t.lowLoadDeletes = 0
+		return
+	}
+
+	t.lowLoadDeletes += n
+	if t.lowLoadDeletes < t.capacity/16 {
+		return
+	}
+
+	t.lowLoadDeletes = 0
+	// Under WithMaxProbe the entries might not fit a smaller table,
+	// it's left untouched then.
+	_ = t.trim(int(t.size * 2))
+}
+
+// mutated counts `n` mutations towards the compaction policy, and runs it
+// every time the count set by WithCompactionPolicy is reached.
+func (t *table[K, V]) mutated(n uintptr) {
+	if t.compactionPolicy == nil {
+		return
+	}
+
+	t.mutations += n
+	if t.mutations < t.compactionPolicyEvery {
+		return
+	}
+
+	t.mutations = 0
+	if t.compactionPolicy(t.Stats()) {
+		t.compact()
+	}
+}
+
+// deleteIf deletes every live entry for which pred returns true
+// and returns the number of deleted entries.
+func (t *table[K, V]) deleteIf(pred func(key K, value V) bool) int {
+	t.completeCompaction()
+
+	var deleted uintptr
+
+	for i := range t.groups {
+		g := &t.groups[i]
+		ctrl := *(*uint64)(unsafe.Pointer(&g.ctrls))
+
+		for matches := matchFull(ctrl); matches != 0; matches = matches.removeFirst() {
+			idx := matches.first()
+			if pred(g.slots[idx], g.values[idx]) {
+				g.ctrls[idx] = slotDeleted
+				deleted++
+			}
+		}
+	}
+
+	// Compact once at the end rather than in the middle of the walk,
+	// compaction moves entries around.
+	if deleted > 0 {
+		t.removed(deleted)
+	}
+
+	return int(deleted)
+}
+
+// pop deletes the first live entry in memory order and returns it.
+func (t *table[K, V]) pop() (K, V, bool) {
+	t.completeCompaction()
+
+	for i := range t.groups {
+		g := &t.groups[i]
+		ctrl := *(*uint64)(unsafe.Pointer(&g.ctrls))
+
+		if matches := matchFull(ctrl); matches != 0 {
+			idx := matches.first()
+			key, value := g.slots[idx], g.values[idx]
+			t.deleteAt(g, idx)
+
+			return key, value, true
+		}
+	}
+
+	var key K
+
+	return key, t.emptyV, false
+}
+
+// all calls yield for every live entry, walking the groups in memory order.
+// It stops as soon as yield returns false.
+func (t *table[K, V]) all(yield func(key K, value V) bool) {
+	for i := range t.groups {
+		g := &t.groups[i]
+		ctrl := *(*uint64)(unsafe.Pointer(&g.ctrls))
+
+		// Entries not relocated yet by a pending compaction are live too
+		for matches := matchFull(ctrl) | matchPending(ctrl); matches != 0; matches = matches.removeFirst() {
+			idx := matches.first()
+			if !yield(g.slots[idx], g.values[idx]) {
+				return
+			}
+		}
+	}
+}
+
+func (t *table[K, V]) Reset() {
+	for i := range t.groups {
+		copy(t.groups[i].ctrls[:], emptyCtrls[:])
+	}
+
+	t.resetCounters()
+}
+
+// resetCounters resets the state describing the content of an empty table.
+func (t *table[K, V]) resetCounters() {
+	t.size = 0
+	t.tombstones = 0
+	t.compacting = false
+	t.compactPos = 0
+	t.lowLoadDeletes = 0
+	t.peakProbeLength = 0
+}
+
+// clone returns a deep copy of the table's storage, with the same configuration.
+// A pending compaction is copied along, and left pending in both.
+func (t *table[K, V]) clone() table[K, V] {
+	c := *t
+	c.groups = slices.Clone(t.groups)
+	c.hashes = slices.Clone(t.hashes)
+
+	return c
+}
+
+// grow resizes the table to the given capacity if it's larger than the current one.
+func (t *table[K, V]) grow(capacity int) error {
+	if capacity < int(t.size) {
+		return ErrCapacityTooSmall
+	}
+
+	if uintptr(capacity) <= t.capacity {
+		return nil
+	}
+
+	if err := validateCapacity[K, V](capacity); err != nil {
+		return err
+	}
+
+	return t.resize(capacity)
+}
+
+// reserve grows the table so that `additional` more entries fit under the load factor.
+// It's a no-op if they already do.
+func (t *table[K, V]) reserve(additional int) error {
+	need := t.size + uintptr(max(additional, 0))
+	if need <= t.capacityEffective {
+		return nil
+	}
+
+	if need > maxCapacity {
+		return fmt.Errorf("%w: %d entries exceed the maximum of %d slots", ErrInvalidCapacity, need, uint(maxCapacity))
+	}
+
+	normalizedCapacity := normalizeCapacity(int(need))
+	for t.effectiveCapacity(normalizedCapacity) < need {
+		if normalizedCapacity >= maxCapacity {
+			return fmt.Errorf("%w: %d entries don't fit under the load factor in %d slots", ErrInvalidCapacity, need, uint(maxCapacity))
+		}
+
+		normalizedCapacity <<= 1
+	}
+
+	if err := validateCapacity[K, V](int(normalizedCapacity)); err != nil {
+		return err
+	}
+
+	return t.resize(int(normalizedCapacity))
+}
+
+// trim shrinks the table to the given capacity, but never below what's needed to
+// hold the current entries. It's a no-op if that isn't smaller than the current capacity.
+func (t *table[K, V]) trim(capacity int) error {
+	normalizedCapacity := normalizeCapacity(capacity)
+	for t.effectiveCapacity(normalizedCapacity) < t.size {
+		normalizedCapacity <<= 1
+	}
+
+	if normalizedCapacity >= t.capacity {
+		return nil
+	}
+
+	return t.resize(int(normalizedCapacity))
+}
+
+// resize moves every live entry into freshly allocated groups of the given
+// capacity, dropping all tombstones. On error the table is left untouched.
+func (t *table[K, V]) resize(capacity int) error {
+	t.completeCompaction()
+
+	var err error
+
+	old := *t
+	t.alloc(capacity)
+
+	// Moving the entries isn't a mutation, and mustn't compact the table midway
+	t.compactionPolicy = nil
+	old.all(func(key K, value V) bool {
+		err = t.set(key, value)
+		return err == nil
+	})
+	t.compactionPolicy = old.compactionPolicy
+
+	if err != nil {
+		*t = old
+		return ErrCapacityTooSmall
+	}
+
+	return nil
+}
+
+// rehash switches the table to the hash function f, re-inserting every live entry
+// into a new table of the same capacity. On error the table is left untouched.
+func (t *table[K, V]) rehash(f HashFunc[K]) error {
+	prev := t.hashFunc
+	t.hashFunc = f
+
+	if err := t.resize(int(t.capacity)); err != nil {
+		t.hashFunc = prev
+		return err
+	}
+
+	return nil
+}
+
+// compactStable drops the tombstones by re-inserting the live entries in
+// memory order into a new table of the same capacity, rather than moving them
+// in place. Entries inserted together stay close to each other.
+// Under WithMaxProbe the new layout might not fit every entry, then the table
+// is left untouched, see postponeCompaction.
+func (t *table[K, V]) compactStable() {
+	if err := t.resize(int(t.capacity)); err != nil {
+		t.postponeCompaction()
+	}
+}
+
+// postponeCompaction raises the tombstone threshold after a compaction that couldn't
+// fit the entries under WithMaxProbe, so that the next deletes don't retry it every
+// time. The threshold is reset once the table is allocated again.
+func (t *table[K, V]) postponeCompaction() {
+	t.tombstoneCompactionThreshold = t.tombstones + t.compactionThreshold()
+}
+
+func (t *table[K, V]) compact() {
+	// The in-place relocation isn't bound by the probe limit
+	if t.maxProbe > 0 {
+		t.compactStable()
+		return
+	}
+
+	if !t.compacting {
+		t.compactBegin()
+	}
+
+	t.compactRelocate(-1)
+}
+
+// CompactParallel runs the in-place compaction with up to `workers` goroutines.
+// The groups are split into as many ranges, and every worker relocates the entries of
+// its range whose probe sequence stays within it. The entries that would cross a range
+// boundary are left pending, and relocated by a final serial pass.
+// The hash function is called concurrently, unless the hashes are cached.
+// Under WithMaxProbe, it rebuilds the table like the serial compaction does.
+// Returns an error if workers is less than 1.
+func (t *table[K, V]) CompactParallel(workers int) error {
+	if workers < 1 {
+		return fmt.Errorf("invalid number of workers: %d", workers)
+	}
+
+	t.completeCompaction()
+
+	workers = min(workers, len(t.groups))
+	if workers <= 1 || t.maxProbe > 0 {
+		t.compact()
+		return nil
+	}
+
+	var (
+		wg    sync.WaitGroup
+		chunk = (len(t.groups) + workers - 1) / workers
+	)
+
+	for lo := 0; lo < len(t.groups); lo += chunk {
+		hi := min(lo+chunk, len(t.groups))
+
+		wg.Go(func() {
+			for i := lo; i < hi; i++ {
+				g := &t.groups[i]
+				ctrl := *(*uint64)(unsafe.Pointer(&g.ctrls))
+				*(*uint64)(unsafe.Pointer(&g.ctrls)) = markPending(ctrl)
+			}
+
+			for slot := uintptr(lo) * groupSize; slot < uintptr(hi)*groupSize; slot++ {
+				if t.groups[slot/groupSize].ctrls[slot%groupSize] == slotPending {
+					t.relocate(slot, uintptr(lo), uintptr(hi))
+				}
+			}
+		})
+	}
+
+	wg.Wait()
+
+	// Reconciliation: relocate the entries that crossed a range boundary
+	t.compacting = true
+	t.compactPos = 0
+	t.compactRelocate(-1)
+
+	return nil
+}
+
+// CompactStep runs the in-place compaction incrementally, to spread its cost over
+// several calls, e.g. from an idle loop. The first call prepares the table, every
+// following call moves at most `budget` entries. Returns true once the compaction
+// is complete, then the next call starts a new one.
+//
+// Lookups and iteration leave a pending compaction as it is, and are slower meanwhile:
+// the entries not relocated yet are compared by key, having lost their h2.
+// Every other operation on the table completes it before running, which then costs
+// a full compaction at once.
+func (t *table[K, V]) CompactStep(budget int) bool {
+	// Under WithMaxProbe, compaction rebuilds the table at once
+	if t.maxProbe > 0 {
+		t.compact()
+		return true
+	}
+
+	if !t.compacting {
+		t.compactBegin()
+		return false
+	}
+
+	return t.compactRelocate(max(budget, 1))
+}
+
+// completeCompaction completes the compaction started by CompactStep, if any.
+// It must be called by every operation writing to the table before accessing it.
+// Lookups and iteration leave a pending compaction as it is, so that they never
+// write to the table, see findCompacting.
+func (t *table[K, V]) completeCompaction() {
+	if t.compacting {
+		t.compactRelocate(-1)
+	}
+}
+
+// compactBegin starts the in-place compaction.
+// Every full slot is marked as pending, to locate the entries left to relocate.
+// Tombstones are kept until they all are: relocating an entry never empties a slot,
+// so probe sequences stay unbroken, and lookups find both the relocated entries
+// and the pending ones meanwhile.
+func (t *table[K, V]) compactBegin() {
+	for i := range t.groups {
+		g := &t.groups[i]
+		ctrl := *(*uint64)(unsafe.Pointer(&g.ctrls))
+		*(*uint64)(unsafe.Pointer(&g.ctrls)) = markPending(ctrl)
+	}
+
+	t.compacting = true
+	t.compactPos = 0
+}
+
+// compactRelocate relocates up to `budget` pending entries, or all of them if budget
+// is negative. Returns true once the compaction is complete.
+func (t *table[K, V]) compactRelocate(budget int) bool {
+	end := uintptr(len(t.groups)) * groupSize
+
+	for ; t.compactPos < end && budget != 0; t.compactPos++ {
+		g := &t.groups[t.compactPos/groupSize]
+		if g.ctrls[t.compactPos%groupSize] != slotPending {
+			continue
+		}
+
+		budget--
+		t.relocate(t.compactPos, 0, uintptr(len(t.groups)))
+	}
+
+	if t.compactPos < end {
+		return false
+	}
+
+	// Every entry is in place, no probe sequence runs through the tombstones anymore
+	for i := range t.groups {
+		g := &t.groups[i]
+		ctrl := *(*uint64)(unsafe.Pointer(&g.ctrls))
+		*(*uint64)(unsafe.Pointer(&g.ctrls)) = dropTombstones(ctrl)
+	}
+
+	t.compacting = false
+	t.tombstones = 0
+
+	return true
+}
+
+// relocate moves the pending entry at the given slot to the first slot along its probe
+// sequence that isn't full, that is holding no relocated entry. If that one holds
+// another pending entry, they're swapped, and the swapped-in entry is relocated in turn:
+// it isn't on its own probe sequence anymore, lookups would miss it.
+//
+// Only the groups in [lo, hi) are accessed. Returns false if a probe sequence leaves
+// them, then the entry at the given slot is left pending, see CompactParallel.
+func (t *table[K, V]) relocate(slot, lo, hi uintptr) bool {
+	g := &t.groups[slot/groupSize]
+	j := slot % groupSize
+
+	for {
+		var (
+			key = g.slots[j]
+			h   uint64
+
+			targetGroup    *group[K, V]
+			targetGroupIdx uintptr
+			targetSlot     uintptr
+		)
+
+		if t.hashes != nil {
+			h = t.hashes[slot]
+		} else {
+			h = t.hashFunc(key)
+		}
+
+		h1, h2 := HashSplit(h)
+		seq := t.probe(h1)
+
+		// Pending entries are on their probe sequence, this stops at their slot at the latest
+		for {
+			if seq.offset < lo || seq.offset >= hi {
+				return false
+			}
+
+			tg := &t.groups[seq.offset]
+			tc := *(*uint64)(unsafe.Pointer(&tg.ctrls))
+			if m := matchEmptyOrDeleted(tc); m != 0 {
+				targetGroup = tg
+				targetGroupIdx = seq.offset
+				targetSlot = m.first()
+				break
+			}
+			seq.next()
+		}
+
+		target := targetGroupIdx*groupSize + targetSlot
+
+		switch {
+		case target == slot:
+			g.ctrls[j] = h2 | slotFull
+
+			return true
+		case targetGroup.ctrls[targetSlot] != slotPending:
+			// Empty or deleted: the entry moves, leaving a tombstone behind
+			targetGroup.ctrls[targetSlot] = h2 | slotFull
+			targetGroup.slots[targetSlot] = key
+			targetGroup.values[targetSlot] = g.values[j]
+			if t.hashes != nil {
+				t.hashes[target] = h
+			}
+			g.ctrls[j] = slotDeleted
+
+			return true
+		default:
+			targetGroup.ctrls[targetSlot] = h2 | slotFull
+			g.slots[j], targetGroup.slots[targetSlot] = targetGroup.slots[targetSlot], g.slots[j]
+			g.values[j], targetGroup.values[targetSlot] = targetGroup.values[targetSlot], g.values[j]
+			if t.hashes != nil {
+				t.hashes[slot], t.hashes[target] = t.hashes[target], t.hashes[slot]
+			}
+		}
+	}
 }
@@ -1,9 +1,16 @@
 package stablemap
 
 import (
+	"math/bits"
 	"math/rand"
+	"os"
+	"os/exec"
+	"runtime"
 	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"unsafe"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -16,6 +23,49 @@ func newTable[K comparable, V any](capacity int, opts ...Option[K, V]) *table[K,
 	return &tt
 }
 
+// probeLength returns the number of groups visited to find a key present in the table.
+func probeLength[K comparable, V any](tt *table[K, V], key K) int {
+	h1, h2 := HashSplit(tt.hashFunc(key))
+	seq := tt.probe(h1)
+
+	for p := uintptr(0); p <= tt.numGroupsMask; p++ {
+		g := &tt.groups[seq.offset]
+		for matches := matchH2(*(*uint64)(unsafe.Pointer(&g.ctrls)), h2); matches != 0; matches = matches.removeFirst() {
+			if g.slots[matches.first()] == key {
+				return int(p) + 1
+			}
+		}
+
+		seq.next()
+	}
+
+	return -1
+}
+
+// maxProbeLength returns the longest probe length over all keys in the table.
+func maxProbeLength[K comparable, V any](tt *table[K, V]) int {
+	var longest int
+	tt.all(func(key K, _ V) bool {
+		longest = max(longest, probeLength(tt, key))
+		return true
+	})
+
+	return longest
+}
+
+func TestGroup_ZeroSizedValues(t *testing.T) {
+	// The same fields with the values stored last
+	type trailingValues struct {
+		ctrls  [groupSize]uint8
+		slots  [groupSize]int
+		values [groupSize]struct{}
+	}
+
+	size := unsafe.Sizeof(group[int, struct{}]{})
+	assert.Equal(t, unsafe.Sizeof([groupSize]uint8{})+unsafe.Sizeof([groupSize]int{}), size)
+	assert.Less(t, size, unsafe.Sizeof(trailingValues{}))
+}
+
 func TestTable_init(t *testing.T) {
 	var tt table[uint64, struct{}]
 
@@ -25,6 +75,80 @@ func TestTable_init(t *testing.T) {
 	require.Equal(t, uintptr((4096/groupSize)-1), tt.numGroupsMask)
 }
 
+// residentBytes returns the resident set size of the process, on Linux.
+func residentBytes(t *testing.T) int {
+	data, err := os.ReadFile("/proc/self/statm")
+	require.NoError(t, err)
+
+	pages, err := strconv.Atoi(strings.Fields(string(data))[1])
+	require.NoError(t, err)
+
+	return pages * os.Getpagesize()
+}
+
+func TestTable_init_Untouched(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("reads the resident set size from /proc")
+	}
+
+	// Memory reused within the process is zeroed by the runtime, which touches it,
+	// so the table is allocated by a fresh process running this test.
+	if os.Getenv("STABLEMAP_TEST_UNTOUCHED") == "" {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestTable_init_Untouched$", "-test.v")
+		cmd.Env = append(os.Environ(), "STABLEMAP_TEST_UNTOUCHED=1")
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+
+		return
+	}
+
+	const capacity = 1 << 24
+	size := capacity / groupSize * int(unsafe.Sizeof(group[uint8, struct{}]{}))
+
+	before := residentBytes(t)
+	tt := newTable(capacity, WithLazyInit[uint8, struct{}]())
+	require.NoError(t, tt.set(42, struct{}{}))
+	_, ok := tt.get(42)
+	require.True(t, ok)
+
+	// Empty control bytes are zero, so the groups aren't written to at init
+	assert.Less(t, residentBytes(t)-before, size/4)
+	runtime.KeepAlive(tt)
+
+	// Without the option, every control byte is written, which touches every page
+	before = residentBytes(t)
+	eager := newTable[uint8, struct{}](capacity)
+	assert.Greater(t, residentBytes(t)-before, size*3/4)
+	runtime.KeepAlive(eager)
+}
+
+func TestTable_WithLazyInit(t *testing.T) {
+	hash := WithHashFunc[int, int](func(k int) uint64 { return HashUint64(uint64(k)) })
+	lazy := newTable(64, hash, WithLazyInit[int, int](), WithAutoCompact[int, int](1))
+	eager := newTable(64, hash, WithAutoCompact[int, int](1))
+	require.Equal(t, eager.groups, lazy.groups)
+
+	for _, tt := range []*table[int, int]{lazy, eager} {
+		for i := range 50 {
+			require.NoError(t, tt.set(i, i))
+		}
+		for i := range 15 {
+			require.True(t, tt.delete(i*3))
+		}
+
+		// Growing allocates new groups
+		require.NoError(t, tt.grow(256))
+		for i := 50; i < 150; i++ {
+			require.NoError(t, tt.set(i, i))
+		}
+	}
+
+	// Only the pages touched differ, not the layout
+	assert.Equal(t, eager.groups, lazy.groups)
+	assert.Equal(t, eager.Stats(), lazy.Stats())
+	require.NoError(t, lazy.CheckInvariants())
+}
+
 func TestTable_init_MinCapacity(t *testing.T) {
 	// Capacity 0 should create a table with 1 group (8 slots)
 	tt := newTable[int, int](0)
@@ -149,7 +273,7 @@ func TestTable_Compact(t *testing.T) {
 	require.Truef(t, ok, "Lost key %d after compaction: %b", lastIdx)
 	require.Equal(t, lastIdx, v)
 
-	// 5. Verify no tombstones (0xFE) remain in the ctrls
+	// 5. Verify no tombstones (0x7E) remain in the ctrls
 	for i := range tt.groups {
 		for j := range groupSize {
 			require.NotEqualf(t, slotDeleted, tt.groups[i].ctrls[j], "Found tombstone at index %d after rehash", i)
@@ -199,6 +323,43 @@ func TestTable_Compact_Sync(t *testing.T) {
 	}
 }
 
+func TestTable_get_TombstoneH2(t *testing.T) {
+	// Every key has the same home group, key 0 has h2=0x7E, like a tombstone's byte
+	tt := newTable(64, WithHashFunc[int, int](func(k int) uint64 {
+		if k == 0 {
+			return 0x7E
+		}
+		return uint64(k)
+	}), WithAutoCompact[int, int](1))
+	for i := range 12 {
+		require.NoError(t, tt.set(i, i))
+	}
+
+	// The home group is full, the chain goes on into the next group
+	require.True(t, tt.delete(0))
+	home := &tt.groups[0]
+	require.Equal(t, uint8(slotDeleted), home.ctrls[0])
+	require.Equal(t, 0, home.slots[0], "the deleted key is still in the slot")
+
+	_, ok := tt.get(0)
+	assert.False(t, ok)
+	assert.False(t, tt.delete(0))
+
+	for i := 1; i < 12; i++ {
+		v, ok := tt.get(i)
+		require.True(t, ok)
+		assert.Equal(t, i, v)
+	}
+
+	// Setting it again takes the tombstone back
+	require.NoError(t, tt.set(0, 42))
+	v, ok := tt.get(0)
+	require.True(t, ok)
+	assert.Equal(t, 42, v)
+	assert.Zero(t, tt.Stats().Tombstones)
+	require.NoError(t, tt.CheckInvariants())
+}
+
 func TestTable_set_BoundaryMirror(t *testing.T) {
 	// 16 slots / 8 per group = 2 groups
 	tt := newTable[int, int](16)
@@ -268,6 +429,38 @@ func TestTable_Stats(t *testing.T) {
 	assert.Equal(t, float32(0), stats.TombstonesSizeRatio)
 }
 
+func TestTable_Stats_PeakProbeLength(t *testing.T) {
+	// Every key starts at group 0, h2 is the key itself
+	tt := newTable(256, WithHashFunc[int, int](func(k int) uint64 {
+		return uint64(k) & 0x7F
+	}), WithAutoCompact[int, int](1))
+	assert.Zero(t, tt.Stats().PeakProbeLength)
+
+	// Each group of the probe sequence takes 8 keys
+	for k := range 40 {
+		require.NoError(t, tt.set(k, k))
+		assert.Equal(t, k/groupSize+1, tt.Stats().PeakProbeLength, "key %d", k)
+	}
+
+	// Overwrites don't count
+	require.NoError(t, tt.set(39, 0))
+	assert.Equal(t, 5, tt.Stats().PeakProbeLength)
+
+	// Nor do deletes and compactions lower it
+	for k := range 32 {
+		require.True(t, tt.delete(k))
+	}
+	tt.compact()
+	assert.Equal(t, 5, tt.Stats().PeakProbeLength)
+
+	// Rebuilding recomputes it from the live entries only
+	require.NoError(t, tt.resize(int(tt.capacity)))
+	assert.Equal(t, 1, tt.Stats().PeakProbeLength)
+
+	tt.Reset()
+	assert.Zero(t, tt.Stats().PeakProbeLength)
+}
+
 func TestTable_NeedsCompaction(t *testing.T) {
 	tt := newTable[int, int](32)
 	threshold := tt.tombstoneCompactionThreshold
@@ -305,3 +498,948 @@ func TestTable_NeedsCompaction_CustomFactor(t *testing.T) {
 	tt.tombstones = threshold
 	assert.True(t, tt.needsCompaction(), "should need compaction at custom threshold")
 }
+
+func TestTable_WithLoadFactor(t *testing.T) {
+	tt := newTable(64, WithLoadFactor[int, int](0.5))
+	require.Equal(t, 32, tt.Stats().EffectiveCapacity)
+
+	for i := range 32 {
+		require.NoError(t, tt.set(i, i))
+	}
+
+	require.ErrorIs(t, tt.set(32, 32), ErrTableFull)
+
+	// Trim keeps honoring the custom load factor
+	for i := range 24 {
+		require.True(t, tt.delete(i))
+	}
+	require.NoError(t, tt.trim(0))
+	assert.Equal(t, 16, tt.Stats().Capacity)
+	assert.Equal(t, 8, tt.Stats().EffectiveCapacity)
+}
+
+func TestTable_WithLoadFactor_Invalid(t *testing.T) {
+	for _, f := range []float64{-1, 0, 1, 1.5} {
+		tt := newTable(64, WithLoadFactor[int, int](f))
+		assert.Equalf(t, 56, tt.Stats().EffectiveCapacity, "load factor %v should be ignored", f)
+	}
+}
+
+func TestTable_WithNoLoadFactorLimit(t *testing.T) {
+	for _, opts := range [][]Option[int, int]{
+		{WithNoLoadFactorLimit[int, int]()},
+		{WithNoLoadFactorLimit[int, int](), WithLinearProbe[int, int]()},
+	} {
+		tt := newTable(256, opts...)
+		require.Equal(t, 256, tt.Stats().EffectiveCapacity)
+
+		for i := range 256 {
+			require.NoError(t, tt.set(i, i))
+		}
+		require.ErrorIs(t, tt.set(256, 256), ErrTableFull)
+		require.NoError(t, tt.CheckInvariants())
+
+		// Every group is full, lookups of missing keys walk all of them
+		_, ok := tt.get(1000)
+		assert.False(t, ok)
+		for i := range 256 {
+			v, ok := tt.get(i)
+			require.True(t, ok)
+			require.Equal(t, i, v)
+		}
+
+		require.True(t, tt.delete(7))
+		require.NoError(t, tt.set(1000, 1000))
+		require.NoError(t, tt.set(1000, 1001))
+		assert.Equal(t, 256, tt.Stats().Size)
+
+		tt.compact()
+		require.NoError(t, tt.CheckInvariants())
+		v, ok := tt.get(1000)
+		require.True(t, ok)
+		assert.Equal(t, 1001, v)
+	}
+}
+
+func TestTable_WithAutoCompact(t *testing.T) {
+	tt := newTable(256, WithAutoCompact[int, int](0.25))
+	effectiveCapacity := tt.Stats().EffectiveCapacity
+
+	// 224 * 0.25 = 56, compaction triggers on the 57th tombstone
+	require.Equal(t, uintptr(57), tt.tombstoneCompactionThreshold)
+
+	for i := range effectiveCapacity {
+		require.NoError(t, tt.set(i, i))
+	}
+
+	// Churn: delete a batch of the oldest keys, then refill with new ones
+	compactions := 0
+	for round := range 4 {
+		base := round * 100
+		for i := base; i < base+100; i++ {
+			before := tt.Stats().Tombstones
+
+			require.True(t, tt.delete(i))
+			if tt.Stats().Tombstones < before {
+				compactions++
+			}
+
+			assert.LessOrEqual(t, tt.Stats().TombstonesCapacityRatio, float32(0.25))
+		}
+
+		for i := base; i < base+100; i++ {
+			require.NoError(t, tt.set(effectiveCapacity+i, i))
+		}
+	}
+
+	assert.Positive(t, compactions, "tombstones should have been reclaimed automatically")
+
+	for i := 400; i < effectiveCapacity+400; i++ {
+		want := i
+		if i >= effectiveCapacity {
+			want = i - effectiveCapacity
+		}
+
+		v, ok := tt.get(i)
+		require.Truef(t, ok, "missing %d after churn", i)
+		assert.Equal(t, want, v)
+	}
+}
+
+func TestTable_WithAutoCompact_Precedence(t *testing.T) {
+	tt := newTable(32,
+		WithAutoCompact[int, int](0.5),
+		WithCompactionThresholdFactor[int, int](4),
+	)
+
+	// 28 * 0.5 = 14, the factor is ignored
+	assert.Equal(t, uintptr(15), tt.tombstoneCompactionThreshold)
+}
+
+func TestTable_WithAutoTrim(t *testing.T) {
+	tt := newTable(1024, WithAutoTrim[int, int](0.1))
+	n := int(tt.capacityEffective)
+	for i := range n {
+		require.NoError(t, tt.set(i, i))
+	}
+
+	// The load drops below 10% at 102 entries left, and must stay there
+	// for 1024/16 deletes before the table shrinks.
+	deleted := 0
+	for ; deleted < n-103+63; deleted++ {
+		require.True(t, tt.delete(deleted))
+	}
+	assert.Equal(t, 1024, tt.Stats().Capacity)
+
+	require.True(t, tt.delete(deleted))
+	deleted++
+
+	stats := tt.Stats()
+	assert.Equal(t, 39, stats.Size)
+	assert.Equal(t, 128, stats.Capacity)
+	assert.Zero(t, stats.Tombstones)
+	require.NoError(t, tt.CheckInvariants())
+
+	for i := deleted; i < n; i++ {
+		v, ok := tt.get(i)
+		require.True(t, ok)
+		assert.Equal(t, i, v)
+	}
+
+	// The trimmed table keeps trimming
+	for ; deleted < n-5; deleted++ {
+		require.True(t, tt.delete(deleted))
+	}
+	assert.Less(t, tt.Stats().Capacity, 128)
+	require.NoError(t, tt.CheckInvariants())
+}
+
+func TestTable_WithAutoTrim_Churn(t *testing.T) {
+	tt := newTable(1024, WithAutoTrim[int, int](0.1))
+	for i := range 100 {
+		require.NoError(t, tt.set(i, i))
+	}
+
+	// Every insert starts the count over, the table doesn't shrink
+	for i := range 1000 {
+		require.True(t, tt.delete(i))
+		require.NoError(t, tt.set(i+100, i))
+	}
+	assert.Equal(t, 1024, tt.Stats().Capacity)
+
+	for i := range 64 {
+		require.True(t, tt.delete(1000+i))
+	}
+	assert.Equal(t, 128, tt.Stats().Capacity)
+}
+
+func TestTable_WithLinearProbe(t *testing.T) {
+	// Every key starts at group 0, h2 is the key itself
+	hashFunc := WithHashFunc[int, int](func(k int) uint64 {
+		return uint64(k)
+	})
+
+	quadratic := newTable(64, hashFunc)
+	linear := newTable(64, hashFunc, WithLinearProbe[int, int]())
+
+	for k := range 32 {
+		require.NoError(t, quadratic.set(k, k))
+		require.NoError(t, linear.set(k, k))
+	}
+
+	// Each group takes 8 keys, then the next one in the sequence is used:
+	// 0, 1, 3, 6 for quadratic probing and 0, 1, 2, 3 for linear probing.
+	for i, group := range []int{0, 1, 3, 6} {
+		assert.Equal(t, []int{i * 8, i*8 + 1, i*8 + 2, i*8 + 3, i*8 + 4, i*8 + 5, i*8 + 6, i*8 + 7}, quadratic.groups[group].slots[:])
+		assert.Equal(t, quadratic.groups[group].slots, linear.groups[i].slots)
+		assert.Equal(t, i+1, probeLength(linear, i*8))
+	}
+
+	for k := range 16 {
+		require.True(t, linear.delete(k))
+	}
+	linear.compact()
+	require.NoError(t, linear.CheckInvariants())
+
+	// Survivors moved back towards the home group
+	assert.Equal(t, 1, probeLength(linear, 16))
+	assert.Equal(t, 2, probeLength(linear, 24))
+}
+
+func TestTable_WithDoubleHashing(t *testing.T) {
+	// Every key starts at group 0, the stride is the key's high bits
+	strided := func(k int) uint64 {
+		return uint64(k)<<(7+bits.UintSize/2) | uint64(k&0x7F)
+	}
+	tt := newTable(64, WithHashFunc[int, int](strided), WithDoubleHashing[int, int]())
+
+	// Fill group 0
+	for k := range 8 {
+		require.NoError(t, tt.set(k<<8, k))
+	}
+
+	// Then each key goes on at its own stride
+	for _, k := range []int{1, 3, 5, 4} {
+		require.NoError(t, tt.set(k, k))
+	}
+	assert.Equal(t, []uintptr{0, 1}, tt.ProbePath(1))
+	assert.Equal(t, []uintptr{0, 3}, tt.ProbePath(3))
+	assert.Equal(t, []uintptr{0, 5}, tt.ProbePath(5))
+	// Strides are odd
+	assert.Equal(t, []uintptr{0, 5}, tt.ProbePath(4))
+	require.NoError(t, tt.CheckInvariants())
+
+	// Every group is reachable with any stride
+	full := newTable(64, WithHashFunc[int, int](strided), WithDoubleHashing[int, int](), WithNoLoadFactorLimit[int, int]())
+	for k := range 64 {
+		require.NoError(t, full.set(3+k<<8, k))
+	}
+	for k := range 64 {
+		v, ok := full.get(3 + k<<8)
+		require.True(t, ok)
+		assert.Equal(t, k, v)
+	}
+	require.NoError(t, full.CheckInvariants())
+
+	// The last of the probing options wins
+	linear := newTable(64, WithDoubleHashing[int, int](), WithBackshiftDelete[int, int]())
+	assert.Zero(t, linear.strideMask)
+	assert.True(t, linear.backshiftDelete)
+	double := newTable(64, WithBackshiftDelete[int, int](), WithDoubleHashing[int, int]())
+	assert.NotZero(t, double.strideMask)
+	assert.False(t, double.backshiftDelete)
+}
+
+func TestTable_WithBackshiftDelete(t *testing.T) {
+	// Every key starts at group 0, h2 is the key itself
+	tt := newTable(64, WithHashFunc[int, int](func(k int) uint64 {
+		return uint64(k)
+	}), WithBackshiftDelete[int, int]())
+
+	for k := range 32 {
+		require.NoError(t, tt.set(k, k))
+	}
+
+	// Keys of groups 1..3 are shifted back into group 0 one after another,
+	// the last one leaves an empty slot in group 3
+	require.True(t, tt.delete(3))
+	require.NoError(t, tt.CheckInvariants())
+	assert.Equal(t, 1, probeLength(tt, 8))
+	assert.Equal(t, uint8(slotEmpty), tt.groups[3].ctrls[0])
+	assert.Zero(t, tt.Stats().Tombstones)
+
+	// Group 3 has an empty slot now, so no chain passes through it
+	require.True(t, tt.delete(31))
+	assert.Equal(t, 4, probeLength(tt, 30))
+
+	for k := 4; k < 31; k++ {
+		require.True(t, tt.delete(k))
+		require.NoError(t, tt.CheckInvariants())
+	}
+	assert.Equal(t, 3, tt.Stats().Size)
+	assert.Zero(t, tt.Stats().Tombstones)
+	assert.Equal(t, 1, maxProbeLength(tt))
+}
+
+func TestTable_WithBackshiftDelete_Churn(t *testing.T) {
+	for _, opts := range [][]Option[int, int]{
+		{WithBackshiftDelete[int, int]()},
+		{WithBackshiftDelete[int, int](), WithCachedHashes[int, int]()},
+		// Clustered: 16 keys per home group
+		{WithBackshiftDelete[int, int](), WithHashFunc[int, int](func(k int) uint64 {
+			return HashUint64(uint64(k))&0x7F | uint64(k/16)<<10
+		})},
+	} {
+		tt := newTable(512, opts...)
+		ref := make(map[int]int)
+		rng := rand.New(rand.NewSource(1))
+
+		for i := range 20000 {
+			k := rng.Intn(600)
+			if rng.Intn(2) == 0 {
+				if _, ok := ref[k]; ok || len(ref) < tt.Stats().EffectiveCapacity {
+					require.NoError(t, tt.set(k, i))
+					ref[k] = i
+				}
+				continue
+			}
+
+			_, ok := ref[k]
+			require.Equal(t, ok, tt.delete(k))
+			delete(ref, k)
+
+			if i%500 == 0 {
+				require.NoError(t, tt.CheckInvariants())
+			}
+		}
+
+		require.NoError(t, tt.CheckInvariants())
+		assert.Zero(t, tt.Stats().Tombstones)
+		assert.Equal(t, len(ref), tt.Stats().Size)
+		for k, v := range ref {
+			got, ok := tt.get(k)
+			require.True(t, ok)
+			require.Equal(t, v, got)
+		}
+	}
+}
+
+func TestTable_WithCachedHashes(t *testing.T) {
+	var calls int
+	tt := newTable(256,
+		WithCachedHashes[string, int](),
+		WithHashFunc[string, int](func(k string) uint64 {
+			calls++
+			return HashUint64(uint64(len(k))*31 + uint64(k[0]))
+		}),
+	)
+	require.Len(t, tt.hashes, 256)
+
+	for i := range 200 {
+		require.NoError(t, tt.set(strconv.Itoa(i), i))
+	}
+	for i := range 50 {
+		require.True(t, tt.delete(strconv.Itoa(i*4)))
+	}
+
+	calls = 0
+	tt.compact()
+	assert.Zero(t, calls, "compact must not hash keys")
+
+	require.NoError(t, tt.CheckInvariants())
+	for i := range 200 {
+		v, ok := tt.get(strconv.Itoa(i))
+		require.Equal(t, i%4 != 0, ok)
+		if ok {
+			assert.Equal(t, i, v)
+		}
+	}
+
+	// Cached hashes survive a resize
+	require.NoError(t, tt.grow(512))
+	require.Len(t, tt.hashes, 512)
+	require.NoError(t, tt.CheckInvariants())
+}
+
+func TestTable_CompactStep(t *testing.T) {
+	build := func() *table[int, int] {
+		tt := newTable(256, WithHashFunc[int, int](func(k int) uint64 {
+			return HashUint64(uint64(k % 50))
+		}), WithAutoCompact[int, int](1))
+
+		for i := range tt.capacityEffective {
+			require.NoError(t, tt.set(int(i), int(i)))
+		}
+		for i := range 100 {
+			require.True(t, tt.delete(i*2))
+		}
+
+		return tt
+	}
+
+	full, stepped := build(), build()
+	require.Equal(t, full.groups, stepped.groups)
+	full.compact()
+
+	steps := 0
+	for !stepped.CompactStep(7) {
+		steps++
+	}
+	// Preparation, then 124 entries at most 7 at a time, with a few extra for swaps
+	assert.GreaterOrEqual(t, steps, 1+124/7)
+
+	assert.Equal(t, full.groups, stepped.groups)
+	assert.Equal(t, full.Stats(), stepped.Stats())
+	assert.Zero(t, stepped.Stats().Tombstones)
+	require.NoError(t, stepped.CheckInvariants())
+
+	// A new compaction starts with the next call
+	assert.False(t, stepped.CompactStep(1))
+	for !stepped.CompactStep(1) {
+	}
+	assert.Equal(t, full.groups, stepped.groups)
+}
+
+func TestTable_CompactStep_Interrupted(t *testing.T) {
+	tt := newTable[int, int](64, WithAutoCompact[int, int](1))
+	for i := range 50 {
+		require.NoError(t, tt.set(i, i))
+	}
+	for i := range 10 {
+		require.True(t, tt.delete(i))
+	}
+
+	require.False(t, tt.CompactStep(5))
+	require.False(t, tt.CompactStep(5))
+
+	// Lookups and iteration leave the pending compaction as it is
+	for i := range 50 {
+		v, ok := tt.get(i)
+		require.Equal(t, i >= 10, ok)
+		if ok {
+			assert.Equal(t, i, v)
+		}
+	}
+
+	var n int
+	tt.all(func(key, value int) bool {
+		assert.Equal(t, key, value)
+		n++
+		return true
+	})
+	assert.Equal(t, 40, n)
+	assert.True(t, tt.compacting)
+
+	// Writes complete it first
+	require.NoError(t, tt.set(50, 50))
+	assert.False(t, tt.compacting)
+	assert.Zero(t, tt.Stats().Tombstones)
+	require.NoError(t, tt.CheckInvariants())
+}
+
+func TestTable_CompactStep_LookupsBetweenSteps(t *testing.T) {
+	// Few home groups, so that entries swap and move into tombstones
+	tt := newTable(256, WithHashFunc[int, int](func(k int) uint64 {
+		return HashUint64(uint64(k % 20))
+	}), WithAutoCompact[int, int](1))
+
+	for i := range tt.capacityEffective {
+		require.NoError(t, tt.set(int(i), int(i)))
+	}
+	for i := range 100 {
+		require.True(t, tt.delete(i*2))
+	}
+
+	check := func() {
+		for i := range int(tt.capacityEffective) {
+			v, ok := tt.get(i)
+			live := i >= 200 || i%2 == 1
+			require.Equal(t, live, ok, "key %d", i)
+			if ok {
+				require.Equal(t, i, v)
+			}
+
+			_, _, probes := tt.getWithProbes(i, tt.hashFunc(i))
+			require.LessOrEqual(t, probes, int(tt.probeLimit)+1)
+		}
+	}
+
+	require.False(t, tt.CompactStep(1))
+	for done := false; !done; {
+		check()
+		done = tt.CompactStep(1)
+	}
+	check()
+
+	assert.Zero(t, tt.Stats().Tombstones)
+	require.NoError(t, tt.CheckInvariants())
+}
+
+func TestTable_CompactParallel(t *testing.T) {
+	hashes := map[string]func(int) uint64{
+		"uniform": func(k int) uint64 { return HashUint64(uint64(k)) },
+		// Few home groups, so that most entries cross a range boundary
+		"clustered": func(k int) uint64 { return HashUint64(uint64(k % 50)) },
+	}
+
+	for name, hash := range hashes {
+		for _, cached := range []bool{false, true} {
+			t.Run(name+"/cached="+strconv.FormatBool(cached), func(t *testing.T) {
+				opts := []Option[int, int]{WithHashFunc[int, int](hash), WithAutoCompact[int, int](1)}
+				if cached {
+					opts = append(opts, WithCachedHashes[int, int]())
+				}
+
+				tt := newTable(1024, opts...)
+				for i := range tt.capacityEffective {
+					require.NoError(t, tt.set(int(i), int(i)))
+				}
+				for i := range 400 {
+					require.True(t, tt.delete(i*2))
+				}
+
+				require.NoError(t, tt.CompactParallel(4))
+				assert.False(t, tt.compacting)
+				assert.Zero(t, tt.Stats().Tombstones)
+				assert.Equal(t, int(tt.capacityEffective)-400, tt.Stats().Size)
+				require.NoError(t, tt.CheckInvariants())
+
+				for i := range int(tt.capacityEffective) {
+					v, ok := tt.get(i)
+					require.Equal(t, i >= 800 || i%2 == 1, ok, "key %d", i)
+					if ok {
+						require.Equal(t, i, v)
+					}
+				}
+			})
+		}
+	}
+
+	tt := newTable[int, int](64)
+	require.Error(t, tt.CompactParallel(0))
+
+	// More workers than groups
+	for i := range 20 {
+		require.NoError(t, tt.set(i, i))
+	}
+	require.True(t, tt.delete(3))
+	require.NoError(t, tt.CompactParallel(100))
+	assert.Zero(t, tt.Stats().Tombstones)
+	assert.Equal(t, 19, tt.Stats().Size)
+	require.NoError(t, tt.CheckInvariants())
+}
+
+func TestTable_WithMaxProbe(t *testing.T) {
+	// Every key starts at group 0, h2 is the key itself
+	tt := newTable(256, WithHashFunc[int, int](func(k int) uint64 {
+		return uint64(k)
+	}), WithMaxProbe[int, int](2))
+	require.Equal(t, uintptr(1), tt.probeLimit)
+
+	// Two groups of 8 slots
+	for k := range 16 {
+		require.NoError(t, tt.set(k, k))
+	}
+	require.ErrorIs(t, tt.set(16, 16), ErrTableFull)
+	assert.Equal(t, 16, tt.Stats().Size)
+
+	// Other home groups still have room
+	require.NoError(t, tt.set(1<<10, 0))
+
+	// Overwrites and lookups of present keys work as usual
+	require.NoError(t, tt.set(15, 150))
+	v, ok := tt.get(15)
+	require.True(t, ok)
+	assert.Equal(t, 150, v)
+
+	// Deleting makes room again
+	require.True(t, tt.delete(3))
+	require.NoError(t, tt.set(16, 16))
+	require.NoError(t, tt.CheckInvariants())
+
+	// The bound can't exceed the number of groups
+	assert.Equal(t, uintptr(0), newTable(8, WithMaxProbe[int, int](4)).probeLimit)
+}
+
+func TestTable_WithMaxProbe_Churn(t *testing.T) {
+	// Compactions must keep every entry within the bound, or lookups miss it
+	hashFunc := WithHashFunc[int, int](func(k int) uint64 {
+		return HashUint64(uint64(k))
+	})
+
+	for seed := range int64(20) {
+		tt := newTable(64, hashFunc, WithMaxProbe[int, int](2))
+		ref := make(map[int]int)
+		r := rand.New(rand.NewSource(seed))
+
+		for step := range 5000 {
+			k := r.Intn(100)
+			if r.Intn(2) == 0 {
+				if err := tt.set(k, step); err == nil {
+					ref[k] = step
+				} else {
+					require.ErrorIs(t, err, ErrTableFull)
+				}
+			} else {
+				_, ok := ref[k]
+				require.Equal(t, ok, tt.delete(k), "seed %d, step %d, key %d", seed, step, k)
+				delete(ref, k)
+			}
+
+			if step%50 == 0 {
+				tt.CompactStep(4)
+			}
+		}
+
+		assert.Equal(t, len(ref), tt.Stats().Size)
+		for k, want := range ref {
+			v, ok := tt.get(k)
+			require.True(t, ok, "seed %d, key %d", seed, k)
+			assert.Equal(t, want, v)
+		}
+		require.NoError(t, tt.CheckInvariants())
+	}
+}
+
+func TestTable_WithCompactionPolicy(t *testing.T) {
+	var calls int
+	tt := newTable(64,
+		// Disable the tombstone threshold, the policy alone decides
+		WithAutoCompact[int, int](1),
+		WithCompactionPolicy[int, int](1, func(stats Stats) bool {
+			calls++
+			return stats.Tombstones > stats.Size
+		}),
+	)
+
+	for i := range 10 {
+		require.NoError(t, tt.set(i, i))
+	}
+	require.NoError(t, tt.set(0, 0))
+	assert.Equal(t, 11, calls)
+
+	for i := range 5 {
+		require.True(t, tt.delete(i))
+	}
+	// 5 tombstones for 5 entries
+	assert.Equal(t, 5, tt.Stats().Tombstones)
+
+	require.True(t, tt.delete(5))
+	assert.Zero(t, tt.Stats().Tombstones)
+	assert.Equal(t, 4, tt.Stats().Size)
+	assert.Equal(t, 17, calls)
+	require.NoError(t, tt.CheckInvariants())
+}
+
+func TestTable_WithCompactionPolicy_Every(t *testing.T) {
+	var calls int
+	tt := newTable(64, WithCompactionPolicy[int, int](10, func(Stats) bool {
+		calls++
+		return true
+	}))
+
+	for i := range 25 {
+		require.NoError(t, tt.set(i, i))
+	}
+	assert.Equal(t, 2, calls)
+
+	// A bulk delete counts every deleted entry
+	assert.Equal(t, 15, tt.deleteIf(func(k, _ int) bool { return k < 15 }))
+	assert.Equal(t, 3, calls)
+	assert.Zero(t, tt.Stats().Tombstones)
+}
+
+func TestTable_ShouldCompact(t *testing.T) {
+	tt := newTable(1024, WithAutoCompact[int, int](1))
+	fill := tt.Stats().EffectiveCapacity
+	for i := range fill {
+		require.NoError(t, tt.set(i, i))
+	}
+	for i := 0; i < fill; i += 2 {
+		require.True(t, tt.delete(i))
+	}
+
+	assert.True(t, tt.ShouldCompact(0.1))
+	assert.False(t, tt.ShouldCompact(0.9))
+
+	// At 7/8 load many entries were pushed past their home group,
+	// and now have a tombstone closer to it
+	benefit := tt.CompactionBenefit()
+	assert.Greater(t, benefit, float32(0))
+	assert.Less(t, benefit, float32(1))
+
+	tt.compact()
+	assert.False(t, tt.ShouldCompact(0.1))
+	assert.Zero(t, tt.CompactionBenefit())
+
+	empty := newTable[int, int](64)
+	assert.False(t, empty.ShouldCompact(0))
+	assert.Zero(t, empty.CompactionBenefit())
+}
+
+func TestTable_TombstoneBytes(t *testing.T) {
+	tt := newTable(64, WithAutoCompact[uint64, [4]uint32](1))
+	for i := range 40 {
+		require.NoError(t, tt.set(uint64(i), [4]uint32{}))
+	}
+	assert.Zero(t, tt.TombstoneBytes())
+
+	for i := range 10 {
+		require.True(t, tt.delete(uint64(i)))
+	}
+	// 1 control byte, 8 bytes of key and 16 bytes of value per tombstone
+	assert.Equal(t, uintptr(10*25), tt.TombstoneBytes())
+
+	tt.compact()
+	assert.Zero(t, tt.TombstoneBytes())
+
+	cached := newTable(64, WithAutoCompact[uint64, [4]uint32](1), WithCachedHashes[uint64, [4]uint32]())
+	require.NoError(t, cached.set(1, [4]uint32{}))
+	require.True(t, cached.delete(1))
+	assert.Equal(t, uintptr(25+8), cached.TombstoneBytes())
+}
+
+func TestTable_Histogram(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		tt := newTable[int, int](64)
+		assert.Equal(t, []int{8, 0, 0, 0, 0, 0, 0, 0, 0}, tt.Histogram())
+	})
+
+	t.Run("uniform", func(t *testing.T) {
+		tt := newTable[int, int](1 << 14)
+		for i := range 1 << 13 {
+			require.NoError(t, tt.set(i, i))
+		}
+
+		histogram := tt.Histogram()
+		require.Len(t, histogram, groupSize+1)
+
+		groups, entries := 0, 0
+		for n, count := range histogram {
+			groups += count
+			entries += n * count
+		}
+		assert.Equal(t, len(tt.groups), groups)
+		assert.Equal(t, 1<<13, entries)
+
+		// Half full on average: most groups hold 3 to 5 entries
+		assert.Greater(t, histogram[3]+histogram[4]+histogram[5], groups/2)
+	})
+
+	t.Run("collisions", func(t *testing.T) {
+		tt := newTable(64, WithHashFunc[int, int](func(int) uint64 { return 0 }))
+		for i := range 20 {
+			require.NoError(t, tt.set(i, i))
+		}
+
+		// Two full groups along the probe sequence, one partially filled, the rest empty
+		assert.Equal(t, []int{5, 0, 0, 0, 1, 0, 0, 0, 2}, tt.Histogram())
+	})
+}
+
+func TestTable_CheckInvariants(t *testing.T) {
+	tt := newTable[int, int](64)
+	require.NoError(t, tt.CheckInvariants())
+
+	for i := range 40 {
+		require.NoError(t, tt.set(i, i))
+	}
+	for i := range 10 {
+		require.True(t, tt.delete(i))
+	}
+	require.NoError(t, tt.CheckInvariants())
+
+	t.Run("size mismatch", func(t *testing.T) {
+		broken := *tt
+		broken.size++
+		assert.ErrorContains(t, broken.CheckInvariants(), "size is")
+	})
+
+	t.Run("tombstones mismatch", func(t *testing.T) {
+		broken := *tt
+		broken.tombstones = 0
+		assert.ErrorContains(t, broken.CheckInvariants(), "tombstones is")
+	})
+
+	t.Run("invalid control byte", func(t *testing.T) {
+		broken := newTable[int, int](16)
+		broken.groups[1].ctrls[3] = 0x10
+		assert.ErrorContains(t, broken.CheckInvariants(), "invalid control byte 0x10")
+	})
+
+	t.Run("unreachable key", func(t *testing.T) {
+		broken := newTable(16, WithHashFunc[int, int](func(int) uint64 { return 0 }))
+		for i := range 10 {
+			require.NoError(t, broken.set(i, i))
+		}
+
+		// Emptying a slot of the full home group cuts the probe chain to the next one
+		broken.groups[0].ctrls[0] = slotEmpty
+		broken.size--
+		assert.ErrorContains(t, broken.CheckInvariants(), "unreachable")
+	})
+}
+
+// FuzzTable applies a random sequence of operations, two bytes each, to a small table
+// with a weak hash function (to force long probe chains) and checks the invariants
+// and the contents against a reference map after every step.
+func FuzzTable(f *testing.F) {
+	f.Add([]byte{0, 1, 0, 2, 0, 3, 1, 2, 0, 2})
+	// Fill up, then delete everything to trigger automatic compaction
+	f.Add([]byte{
+		0, 7, 0, 15, 0, 23, 0, 31, 0, 39, 0, 47, 0, 55, 0, 63,
+		1, 7, 1, 15, 1, 23, 1, 31, 1, 39, 1, 47, 1, 55,
+	})
+	// Interleaved churn with explicit compactions
+	f.Add([]byte{0, 1, 0, 9, 0, 17, 1, 9, 2, 0, 0, 25, 1, 1, 2, 0, 0, 9})
+
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		hashFunc := WithHashFunc[uint8, int](func(k uint8) uint64 {
+			return uint64(k%8) << 10
+		})
+
+		t.Run("default", func(t *testing.T) {
+			fuzzTable(t, ops, newTable(32, hashFunc))
+		})
+		t.Run("max probe", func(t *testing.T) {
+			fuzzTable(t, ops, newTable(32, hashFunc, WithMaxProbe[uint8, int](2)))
+		})
+	})
+}
+
+// fuzzTable runs the operations encoded in ops against tt and a Go map.
+func fuzzTable(t *testing.T, ops []byte, tt *table[uint8, int]) {
+	ref := make(map[uint8]int)
+
+	for i := 0; i+1 < len(ops); i += 2 {
+		key := ops[i+1] % 64
+
+		switch ops[i] % 4 {
+		case 0:
+			err := tt.set(key, i)
+			if err != nil {
+				require.ErrorIs(t, err, ErrTableFull)
+				if tt.maxProbe == 0 {
+					require.Len(t, ref, tt.Stats().EffectiveCapacity)
+				}
+				break
+			}
+			ref[key] = i
+		case 1:
+			_, ok := ref[key]
+			require.Equal(t, ok, tt.delete(key))
+			delete(ref, key)
+		case 2:
+			tt.compact()
+		case 3:
+			// Left unfinished, the next operation completes it
+			tt.CompactStep(int(key % 8))
+			continue
+		}
+
+		require.NoError(t, tt.CheckInvariants())
+		require.Equal(t, len(ref), tt.Stats().Size)
+
+		for k, v := range ref {
+			got, ok := tt.get(k)
+			require.True(t, ok)
+			require.Equal(t, v, got)
+		}
+	}
+}
+
+func TestTable_WalkSlots(t *testing.T) {
+	// h2 is the key itself, all keys start at group 0
+	tt := newTable(32, WithHashFunc[int, int](func(k int) uint64 {
+		return uint64(k) & 0x7F
+	}), WithAutoCompact[int, int](1))
+
+	for k := range 10 {
+		require.NoError(t, tt.set(k, k*2))
+	}
+	require.True(t, tt.delete(2))
+	require.True(t, tt.delete(9))
+
+	var slots int
+	tt.WalkSlots(func(groupIdx, slotIdx int, ctrl uint8, key, value int) {
+		slots++
+
+		// Group 0 holds 0..7, group 1 holds 8 and 9
+		k := groupIdx*8 + slotIdx
+		switch {
+		case k == 2 || k == 9:
+			assert.Equal(t, uint8(slotDeleted), ctrl)
+		case k < 10:
+			assert.Equal(t, uint8(k)|slotFull, ctrl)
+			assert.Equal(t, k, key)
+			assert.Equal(t, k*2, value)
+		default:
+			assert.Equal(t, uint8(slotEmpty), ctrl)
+		}
+	})
+	assert.Equal(t, tt.Stats().Capacity, slots)
+}
+
+func TestTable_ProbePath(t *testing.T) {
+	// h2 is the key itself, all keys start at group 0
+	tt := newTable(256, WithHashFunc[int, int](func(k int) uint64 {
+		return uint64(k) & 0x7F
+	}))
+
+	// Keys fill the groups along the probe sequence, 8 at a time
+	for k := range 40 {
+		require.NoError(t, tt.set(k, k))
+	}
+
+	assert.Equal(t, []uintptr{0}, tt.ProbePath(3))
+	assert.Equal(t, []uintptr{0, 1, 3}, tt.ProbePath(20))
+	assert.Equal(t, []uintptr{0, 1, 3, 6, 10}, tt.ProbePath(39))
+
+	// A missing key stops at the first group with an empty slot
+	assert.Equal(t, []uintptr{0, 1, 3, 6, 10, 15}, tt.ProbePath(100))
+
+	linear := newTable(256, WithHashFunc[int, int](func(k int) uint64 {
+		return uint64(k) & 0x7F
+	}), WithLinearProbe[int, int]())
+	for k := range 40 {
+		require.NoError(t, linear.set(k, k))
+	}
+	assert.Equal(t, []uintptr{0, 1, 2, 3, 4}, linear.ProbePath(39))
+}
+
+func TestTable_SameHomeGroup(t *testing.T) {
+	// The key is h1, h2 is always 0
+	tt := newTable(256, WithHashFunc[int, int](func(k int) uint64 {
+		return uint64(k) << 7
+	}))
+
+	// Groups take 8 consecutive h1 values, 256 slots are 32 groups
+	assert.True(t, tt.SameHomeGroup(0, 7))
+	assert.True(t, tt.SameHomeGroup(9, 14))
+	assert.False(t, tt.SameHomeGroup(7, 8))
+	assert.False(t, tt.SameHomeGroup(0, 100))
+
+	// Group indices wrap around the table
+	assert.True(t, tt.SameHomeGroup(3, 256+3))
+	larger := newTable(512, WithHashFunc[int, int](func(k int) uint64 {
+		return uint64(k) << 7
+	}))
+	assert.False(t, larger.SameHomeGroup(3, 256+3))
+}
+
+func TestTable_Dump(t *testing.T) {
+	// h2 is the key itself, all keys start at group 0
+	tt := newTable(16, WithHashFunc[int, int](func(k int) uint64 {
+		return uint64(k)
+	}))
+
+	for _, k := range []int{0x11, 0x22, 0x33, 0x7F} {
+		require.NoError(t, tt.set(k, k))
+	}
+	require.True(t, tt.delete(0x22))
+
+	want := "" +
+		"0: 11=17 D 33=51 7F=127 E E E E\n" +
+		"1: E E E E E E E E\n"
+	assert.Equal(t, want, tt.Dump())
+}
@@ -1,15 +1,73 @@
 package stablemap
 
 import (
+	"fmt"
+	"math"
 	"math/bits"
 	"unsafe"
 )
 
-// Returns the next power of 2 for the given value `v`.
+const (
+	// maxCapacity is the largest power of 2 representable by a uint32.
+	maxCapacity = 1 << 31
+	// maxAllocation is the largest number of bytes the Go runtime allocates at once
+	// on 64-bit platforms, 2^48. It's bound by the address space on 32-bit ones.
+	maxAllocation = min(1<<48, math.MaxInt)
+)
+
+// Returns the smallest power of 2 greater than or equal to `v`, and 1 for 0.
+// The result saturates at 2^31, the largest power of 2 a uint32 holds:
+// for `v` above it, the result is smaller than `v`.
 func NextPowerOf2(v uint32) uint32 {
+	if v <= 1 {
+		return 1
+	}
+
 	return uint32(1) << min(bits.Len32(v-1), 31)
 }
 
+// Returns the number of slots actually allocated for the requested capacity:
+// at least one group, rounded up to the next power of 2, and at most 2^31.
+func normalizeCapacity(capacity int) uintptr {
+	return uintptr(NextPowerOf2(uint32(min(uint(max(capacity, groupSize)), maxCapacity))))
+}
+
+// Checks that the capacity is positive and that its groups storage can be allocated.
+func validateCapacity[K comparable, V any](capacity int) error {
+	if capacity <= 0 {
+		return fmt.Errorf("%w: %d is not positive", ErrInvalidCapacity, capacity)
+	}
+
+	if uint(capacity) > maxCapacity {
+		return fmt.Errorf("%w: %d exceeds the maximum of %d slots", ErrInvalidCapacity, capacity, uint(maxCapacity))
+	}
+
+	numGroups := normalizeCapacity(capacity) / groupSize
+	if size := unsafe.Sizeof(group[K, V]{}); size > 0 && numGroups > maxAllocation/size {
+		return fmt.Errorf("%w: %d groups of %d bytes exceed the maximum allocation size", ErrInvalidCapacity, numGroups, size)
+	}
+
+	return nil
+}
+
+// Returns the capacity needed to hold `n` entries under the 7/8 load factor.
+func capacityForSize(n int) int {
+	return (n*8 + 6) / 7
+}
+
+// Returns the capacity to pass to New for the map to hold `n` entries under the
+// default 7/8 load factor without returning ErrTableFull: `n` plus the headroom,
+// rounded up to the next power of 2. It's the smallest such capacity, and at most 2^31,
+// or math.MaxInt on 32-bit platforms, which New rounds to 2^31 as well.
+// Maps built WithLoadFactor or WithMaxProbe need more, see Reserve.
+func CapacityForElements(n int) int {
+	// Computed in 64 bits, n*8 overflows an int on 32-bit platforms
+	n64 := min(uint64(max(n, 0)), maxCapacity)
+	capacity := min((n64*8+6)/7, maxCapacity)
+
+	return int(min(uint64(max(NextPowerOf2(uint32(capacity)), groupSize)), math.MaxInt))
+}
+
 // Estimates capacity (number of slots) from the given memory size in bytes.
 func CapacityFromSize[K comparable, V any](size uintptr) int {
 	sizeOfGroup := unsafe.Sizeof(group[K, V]{})
@@ -1,6 +1,7 @@
 package stablemap
 
 import (
+	"math"
 	"testing"
 	"unsafe"
 
@@ -63,3 +64,62 @@ func TestCapacityFromSize(t *testing.T) {
 		require.Equal(t, 4*7, stats.EffectiveCapacity)
 	})
 }
+
+func TestNextPowerOf2(t *testing.T) {
+	tests := []struct {
+		v    uint32
+		want uint32
+	}{
+		{0, 1},
+		{1, 1},
+		{2, 2},
+		{3, 4},
+		{1000, 1024},
+		{1 << 31, 1 << 31},
+		// Saturated: there's no larger power of 2 in a uint32
+		{1<<31 + 1, 1 << 31},
+		{math.MaxUint32, 1 << 31},
+	}
+
+	for _, tt := range tests {
+		require.Equalf(t, tt.want, NextPowerOf2(tt.v), "NextPowerOf2(%d)", tt.v)
+	}
+}
+
+func TestNormalizeCapacity(t *testing.T) {
+	require.Equal(t, uintptr(groupSize), normalizeCapacity(-1))
+	require.Equal(t, uintptr(groupSize), normalizeCapacity(0))
+	require.Equal(t, uintptr(1024), normalizeCapacity(1000))
+	// Saturated rather than truncated to the lower 32 bits
+	require.Equal(t, uintptr(1<<31), normalizeCapacity(math.MaxInt))
+}
+
+func TestCapacityForElements(t *testing.T) {
+	for _, tt := range []struct{ n, want int }{
+		{-1, 8},
+		{0, 8},
+		{7, 8},
+		{8, 16},
+		{896, 1024},
+		{897, 2048},
+		{1000, 2048},
+		{math.MaxInt, min(1<<31, math.MaxInt)},
+	} {
+		require.Equal(t, tt.want, CapacityForElements(tt.n), "n=%d", tt.n)
+	}
+
+	sm := New[int, int](CapacityForElements(1000))
+	for i := range 1000 {
+		require.NoError(t, sm.Set(i, i))
+	}
+
+	// The smallest capacity that fits
+	for n := 1; n <= 2000; n++ {
+		capacity := CapacityForElements(n)
+		stats := New[int, int](capacity).Stats()
+		require.GreaterOrEqual(t, stats.EffectiveCapacity, n, "n=%d", n)
+		if capacity > groupSize {
+			require.Less(t, New[int, int](capacity/2).Stats().EffectiveCapacity, n, "n=%d", n)
+		}
+	}
+}
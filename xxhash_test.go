@@ -0,0 +1,85 @@
+package stablemap
+
+import (
+	"fmt"
+	"hash/maphash"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestXXHash64(t *testing.T) {
+	tests := []struct {
+		input string
+		want  uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"as", 0x1c330fb2d66be179},
+		{"asd", 0x631c37ce72a97393},
+		{"asdf", 0x415872f599cea71e},
+		{"Call me Ishmael. Some years ago--never mind how long precisely-", 0x02a2e85470d6fd96},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			require.Equal(t, tt.want, MakeXXHashFunc[string](0)(tt.input))
+		})
+	}
+}
+
+func TestXXHash64_Seed(t *testing.T) {
+	require.NotEqual(t, MakeXXHashFunc[string](0)("foo"), MakeXXHashFunc[string](1)("foo"))
+}
+
+func TestXXHash64_Distribution(t *testing.T) {
+	const n = 128 * 512
+
+	hashFunc := MakeXXHashFunc[string](42)
+
+	var h2Counts [128]int
+	for i := range n {
+		_, h2 := HashSplit(hashFunc(fmt.Sprintf("key-%032d", i)))
+		h2Counts[h2]++
+	}
+
+	avg := n / len(h2Counts)
+	for h2, count := range h2Counts {
+		require.InDeltaf(t, avg, count, float64(avg)/4, "h2 0x%02X is skewed", h2)
+	}
+
+	// Full 64-bit output: keys land in every group of a large table
+	tt := newTable(n*2, WithHashFunc[string, struct{}](hashFunc))
+	for i := range n {
+		require.NoError(t, tt.set(fmt.Sprintf("key-%032d", i), struct{}{}))
+	}
+	require.LessOrEqual(t, maxProbeLength(tt), 4)
+}
+
+func benchmarkStringKeys() []string {
+	keys := make([]string, 4096)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%s%08d", strings.Repeat("k", 24), i)
+	}
+
+	return keys
+}
+
+func BenchmarkHash_String32_Default(b *testing.B) {
+	keys := benchmarkStringKeys()
+	hashFunc := MakeDefaultHashFunc[string](maphash.MakeSeed())
+
+	for i := 0; b.Loop(); i++ {
+		hashFunc(keys[i&4095])
+	}
+}
+
+func BenchmarkHash_String32_XXHash(b *testing.B) {
+	keys := benchmarkStringKeys()
+	hashFunc := MakeXXHashFunc[string](0)
+
+	for i := 0; b.Loop(); i++ {
+		hashFunc(keys[i&4095])
+	}
+}